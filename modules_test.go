@@ -1,10 +1,13 @@
 package tengolib
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
 	"github.com/d5/tengo/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/tengolib/tengocontext"
 )
 
 func TestTengocollection(t *testing.T) {
@@ -45,3 +48,29 @@ func TestTengocollection(t *testing.T) {
 	v = c.Get("orderBy")
 	fmt.Println(v)
 }
+
+func TestContextCurrentPerRun(t *testing.T) {
+	withTraceId := func(traceId string) context.Context {
+		return context.WithValue(context.Background(), tengocontext.ContextKey("traceId"), traceId)
+	}
+	script := tengo.NewScript([]byte(`
+	traceId:=context.current().value("traceId")
+	ctxTraceId:=ctx.value("traceId")
+	`))
+	script.SetImports(GetModuleMap(AllModuleNames()...))
+	require.NoError(t, tengocontext.AddToScript(script, "ctx", withTraceId("abc")))
+	compiled, err := script.Compile()
+	require.NoError(t, err)
+	require.NoError(t, compiled.Run())
+	require.Equal(t, "abc", compiled.Get("traceId").String())
+
+	// 编译一次,每次运行替换上下文
+	clone := compiled.Clone()
+	require.NoError(t, tengocontext.SetToCompiled(clone, "ctx", withTraceId("def")))
+	require.NoError(t, clone.Run())
+	require.Equal(t, "def", clone.Get("traceId").String())
+	require.Equal(t, "def", clone.Get("ctxTraceId").String())
+	require.NoError(t, compiled.Run())
+	require.Equal(t, "abc", compiled.Get("traceId").String())
+	require.Error(t, tengocontext.AddToScript(script, tengocontext.CTX_MODULE_NAME, context.Background()))
+}
@@ -0,0 +1,232 @@
+package tengobin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/logchan/v2"
+)
+
+type LogName string
+
+func (l LogName) String() string {
+	return string(l)
+}
+
+const (
+	LOG_INFO_BIN LogName = "LogInfoBin"
+)
+
+type LogInfoBin struct {
+	Context  context.Context
+	Command  string    `json:"command"`
+	Args     []string  `json:"args"`
+	ExitCode int       `json:"exitCode"`
+	Err      error     `json:"error"`
+	BeginAt  time.Time `json:"beginAt"`
+	EndAt    time.Time `json:"endAt"`
+	Duration string    `json:"time"`
+	logchan.EmptyLogInfo
+}
+
+func (l *LogInfoBin) GetName() logchan.LogName {
+	return LOG_INFO_BIN
+}
+func (l *LogInfoBin) Error() error {
+	return l.Err
+}
+
+// BinConfig 资源配置,命令及参数固定在配置中,脚本只能通过标准输入传递数据
+type BinConfig struct {
+	Command string            `json:"command"` // 可执行文件,绝对路径、PATH 中的名称或相对 Dir 的路径
+	Args    []string          `json:"args"`    // 固定参数
+	Dir     string            `json:"dir"`     // 工作目录,空表示当前进程工作目录
+	Env     map[string]string `json:"env"`     // 追加的环境变量
+	Timeout string            `json:"timeout"` // 单次执行超时时间,如 "30s",空表示不限制(仍受 ctx 控制)
+}
+
+// ParseTimeout 解析超时时间
+func (cfg BinConfig) ParseTimeout() (timeout time.Duration, err error) {
+	if cfg.Timeout == "" {
+		return 0, nil
+	}
+	timeout, err = time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		err = errors.WithMessagef(err, "timeout:%s", cfg.Timeout)
+		return 0, err
+	}
+	return timeout, nil
+}
+
+// Environ 当前进程环境变量追加配置中的环境变量
+func (cfg BinConfig) Environ() (env []string) {
+	env = os.Environ()
+	keys := make([]string, 0, len(cfg.Env))
+	for k := range cfg.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+cfg.Env[k])
+	}
+	return env
+}
+
+var (
+	allowedCommands = make(map[string]bool) // 允许执行的命令绝对路径
+	allowedLock     sync.RWMutex
+)
+
+// SetAllowedCommands 设置(替换)允许执行的命令,名称按当前 PATH 解析为绝对路径后保存;未设置时拒绝所有命令,由宿主在创建资源前设置
+func SetAllowedCommands(commands ...string) (err error) {
+	allowed := make(map[string]bool, len(commands))
+	for _, command := range commands {
+		path, err := resolveCommand(command, "")
+		if err != nil {
+			return err
+		}
+		allowed[path] = true
+	}
+	allowedLock.Lock()
+	defer allowedLock.Unlock()
+	allowedCommands = allowed
+	return nil
+}
+
+// AllowedCommands 获取允许执行的命令绝对路径
+func AllowedCommands() (commands []string) {
+	allowedLock.RLock()
+	defer allowedLock.RUnlock()
+	commands = make([]string, 0, len(allowedCommands))
+	for command := range allowedCommands {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return commands
+}
+
+// CheckAllowed 将命令解析为绝对路径后检查是否允许执行,返回实际执行的路径;dir 为命令工作目录,用于解析相对路径
+func CheckAllowed(command string, dir string) (path string, err error) {
+	path, err = resolveCommand(command, dir)
+	if err != nil {
+		return "", err
+	}
+	allowedLock.RLock()
+	defer allowedLock.RUnlock()
+	if len(allowedCommands) == 0 {
+		err = errors.Errorf("command %s not allowed, AllowedCommands is empty", command)
+		return "", err
+	}
+	if !allowedCommands[path] {
+		err = errors.Errorf("command %s(%s) not in AllowedCommands", command, path)
+		return "", err
+	}
+	return path, nil
+}
+
+// resolveCommand 与 exec.Cmd 一致:含路径分隔符的相对路径相对 dir 解析,否则在 PATH 中查找
+func resolveCommand(command string, dir string) (path string, err error) {
+	if dir != "" && !filepath.IsAbs(command) && strings.ContainsRune(command, filepath.Separator) {
+		command = filepath.Join(dir, command)
+	}
+	path, err = exec.LookPath(command)
+	if err != nil {
+		err = errors.WithMessagef(err, "command:%s", command)
+		return "", err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		err = errors.WithMessagef(err, "command:%s", command)
+		return "", err
+	}
+	return path, nil
+}
+
+// BinResult 执行结果,非 0 退出码不视为错误
+type BinResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+}
+
+func (r BinResult) String() string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+// Run 执行命令,input 写入标准输入;ctx 取消或超时时结束进程(含其子进程)并返回错误
+func Run(ctx context.Context, cfg BinConfig, input string) (result *BinResult, err error) {
+	logInfo := &LogInfoBin{Context: ctx, Command: cfg.Command, Args: cfg.Args}
+	defer func() {
+		logInfo.Err = err
+		duration := float64(logInfo.EndAt.Sub(logInfo.BeginAt).Nanoseconds()) / 1e6
+		logInfo.Duration = fmt.Sprintf("%.3fms", duration)
+		logchan.SendLogInfo(logInfo)
+	}()
+	path, err := CheckAllowed(cfg.Command, cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := cfg.ParseTimeout()
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.Command(path, cfg.Args...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = cfg.Environ()
+	cmd.Stdin = strings.NewReader(input)
+	setProcessGroup(cmd)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	logInfo.BeginAt = time.Now().Local()
+	err = cmd.Start()
+	if err == nil {
+		// ctx 结束时结束整个进程组,避免子进程残留并占用输出管道使 Wait 阻塞
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				killProcessGroup(cmd)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+	logInfo.EndAt = time.Now().Local()
+	result = &BinResult{
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+	}
+	if ctx.Err() != nil {
+		err = errors.WithMessagef(ctx.Err(), "command %s killed", cfg.Command)
+		return nil, err
+	}
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			err = errors.WithMessagef(err, "command:%s", cfg.Command)
+			return nil, err
+		}
+		result.ExitCode = exitErr.ExitCode()
+		err = nil
+	}
+	logInfo.ExitCode = result.ExitCode
+	return result, nil
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package tengobin
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup 命令在独立进程组中运行
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup 向命令所在进程组发送 SIGKILL
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,14 @@
+package tengobin
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup windows 无进程组
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// killProcessGroup windows 只结束命令进程
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
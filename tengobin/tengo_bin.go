@@ -0,0 +1,135 @@
+package tengobin
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+
+	"github.com/d5/tengo/v2"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/tengolib/tengocontext"
+	"github.com/suifengpiao14/tengolib/tengosource"
+)
+
+// TengoBin 本地命令资源,脚本中通过 execOrQueryContext(ctx,input) 将模板输出写入命令标准输入
+type TengoBin struct {
+	tengo.ImmutableMap
+	config BinConfig
+}
+
+func (tengoBin *TengoBin) TypeName() string {
+	return "bin"
+}
+func (tengoBin *TengoBin) String() string {
+	return ""
+}
+
+// init 导入本包即注册 BIN 资源类型
+func init() {
+	tengosource.RegisterProviderFactory(tengosource.PROVIDER_BIN, func(config string) (tengosource.Provider, error) {
+		return NewTengoBin(config)
+	})
+}
+
+// NewTengoBin config 为 BinConfig 的 json 格式,command 必须可执行且在 SetAllowedCommands 设置的命令中;每次执行前按当时的 PATH 重新检查
+func NewTengoBin(config string) (tengoBin *TengoBin, err error) {
+	cfg := BinConfig{}
+	err = json.Unmarshal([]byte(config), &cfg)
+	if err != nil {
+		err = errors.WithMessagef(err, "bin config:%s", config)
+		return nil, err
+	}
+	if cfg.Command == "" {
+		err = errors.Errorf("bin config command required:%s", config)
+		return nil, err
+	}
+	_, err = CheckAllowed(cfg.Command, cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	_, err = cfg.ParseTimeout()
+	if err != nil {
+		return nil, err
+	}
+	tengoBin = &TengoBin{
+		ImmutableMap: tengo.ImmutableMap{
+			Value: make(map[string]tengo.Object),
+		},
+		config: cfg,
+	}
+	//注入tengo 脚本方法
+	methods := map[string]tengo.CallableFunc{
+		"execOrQueryContext": tengoBin.TengoExecOrQueryContext,
+	}
+	for key, method := range methods {
+		tengoBin.Value[key] = &tengo.UserFunction{
+			Name:  key,
+			Value: method,
+		}
+	}
+	return tengoBin, nil
+}
+
+// Close 命令资源无需释放
+func (tengoBin *TengoBin) Close() (err error) {
+	return nil
+}
+
+// Health 检查命令是否仍可执行
+func (tengoBin *TengoBin) Health(ctx context.Context) (err error) {
+	_, err = exec.LookPath(tengoBin.config.Command)
+	if err != nil {
+		err = errors.WithMessagef(err, "command:%s", tengoBin.config.Command)
+		return err
+	}
+	return nil
+}
+
+// Run 执行命令,返回完整结果
+func (tengoBin *TengoBin) Run(ctx context.Context, input string) (result *BinResult, err error) {
+	return Run(ctx, tengoBin.config, input)
+}
+
+// ExecOrQueryContext 与 TengoDB 签名一致,返回结果的 json 格式(stdout、stderr、exitCode)
+func (tengoBin *TengoBin) ExecOrQueryContext(ctx context.Context, input string) (out string, err error) {
+	result, err := tengoBin.Run(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// TengoExecOrQueryContext 返回 {stdout,stderr,exitCode}
+func (tengoBin *TengoBin) TengoExecOrQueryContext(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	ctxObj, ok := args[0].(*tengocontext.TengoContext)
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "context",
+			Expected: "context.Context",
+			Found:    args[0].TypeName(),
+		}
+	}
+	input, ok := tengo.ToString(args[1])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "input",
+			Expected: "string",
+			Found:    args[1].TypeName(),
+		}
+	}
+	result, err := tengoBin.Run(ctxObj.Context, input)
+	if err != nil {
+		return nil, err
+	}
+	ret = &tengo.ImmutableMap{
+		Value: map[string]tengo.Object{
+			"stdout":   &tengo.String{Value: result.Stdout},
+			"stderr":   &tengo.String{Value: result.Stderr},
+			"exitCode": &tengo.Int{Value: int64(result.ExitCode)},
+		},
+	}
+	return ret, nil
+}
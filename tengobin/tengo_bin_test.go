@@ -0,0 +1,91 @@
+package tengobin
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/d5/tengo/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/tengolib/tengocontext"
+)
+
+func allowCommands(t *testing.T, commands ...string) {
+	require.NoError(t, SetAllowedCommands(commands...))
+	t.Cleanup(func() { SetAllowedCommands() })
+}
+
+func TestTengoBin(t *testing.T) {
+	allowCommands(t, "sh")
+	tengoBin, err := NewTengoBin(`{"command":"sh","args":["-c","cat; echo $APP_NAME; pwd; echo oops >&2; exit 3"],"dir":"/","env":{"APP_NAME":"tengolib"},"timeout":"5s"}`)
+	require.NoError(t, err)
+	result, err := tengoBin.Run(context.Background(), "hello\n")
+	require.NoError(t, err)
+	require.Equal(t, "hello\ntengolib\n/\n", result.Stdout)
+	require.Equal(t, "oops\n", result.Stderr)
+	require.Equal(t, 3, result.ExitCode)
+
+	script := tengo.NewScript([]byte(`out:=bin.execOrQueryContext(ctx,"world\n")`))
+	require.NoError(t, script.Add("bin", tengoBin))
+	require.NoError(t, script.Add("ctx", tengocontext.NewTengoContext(context.Background())))
+	c, err := script.Run()
+	require.NoError(t, err)
+	out := tengo.ToInterface(c.Get("out").Object()).(map[string]interface{})
+	require.Equal(t, int64(3), out["exitCode"])
+}
+
+func TestTengoBinCancel(t *testing.T) {
+	allowCommands(t, "sleep", "sh")
+	tengoBin, err := NewTengoBin(`{"command":"sleep","args":["10"]}`)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err = tengoBin.Run(ctx, "")
+	require.Error(t, err)
+	require.Less(t, time.Since(begin), 5*time.Second)
+
+	// 子进程继承输出管道,只结束 sh 时 Run 会等到子进程退出
+	tengoBin, err = NewTengoBin(`{"command":"sh","args":["-c","sleep 10; echo done"],"timeout":"50ms"}`)
+	require.NoError(t, err)
+	begin = time.Now()
+	_, err = tengoBin.Run(context.Background(), "")
+	require.Error(t, err)
+	require.Less(t, time.Since(begin), 5*time.Second)
+}
+
+func TestTengoBinAllowedCommands(t *testing.T) {
+	_, err := NewTengoBin(`{"command":"cat"}`)
+	require.ErrorContains(t, err, "AllowedCommands is empty")
+
+	allowCommands(t, "cat")
+	_, err = NewTengoBin(`{"command":"sh"}`)
+	require.Error(t, err)
+	_, err = NewTengoBin(`{"command":"cat"}`)
+	require.NoError(t, err)
+	_, err = NewTengoBin(`{"command":""}`)
+	require.Error(t, err)
+
+	// 按绝对路径比较,相对路径及 PATH 变化不能绕过
+	cat, err := exec.LookPath("cat")
+	require.NoError(t, err)
+	require.Equal(t, []string{cat}, AllowedCommands())
+	_, err = NewTengoBin(`{"command":"` + cat + `"}`)
+	require.NoError(t, err)
+	_, err = NewTengoBin(`{"command":"./cat","dir":"` + filepath.Dir(cat) + `"}`)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	fake := filepath.Join(dir, "cat")
+	require.NoError(t, os.WriteFile(fake, []byte("#!/bin/sh\necho fake\n"), 0755))
+	_, err = NewTengoBin(`{"command":"./cat","dir":"` + dir + `"}`)
+	require.Error(t, err)
+	tengoBin, err := NewTengoBin(`{"command":"cat"}`)
+	require.NoError(t, err)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	_, err = tengoBin.Run(context.Background(), "")
+	require.Error(t, err)
+}
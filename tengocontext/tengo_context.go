@@ -2,13 +2,19 @@ package tengocontext
 
 import (
 	context "context"
+	"time"
 
 	"github.com/d5/tengo/v2"
+	"github.com/pkg/errors"
 )
 
+// ContextKey 脚本中 withValue 写入的键类型,宿主使用该类型写入的值可在脚本中通过 value(key) 读取
+type ContextKey string
+
 type TengoContext struct {
 	tengo.ObjectImpl
 	Context context.Context
+	cancel  context.CancelFunc
 }
 
 func (c *TengoContext) TypeName() string {
@@ -18,7 +24,144 @@ func (c *TengoContext) String() string {
 	return "context"
 }
 
-var Ctx = map[string]tengo.Object{
+// Copy tengo.Compiled.Clone 复制全局变量时保留上下文
+func (c *TengoContext) Copy() tengo.Object {
+	return &TengoContext{Context: c.Context, cancel: c.cancel}
+}
+
+// NewTengoContext 包装 context.Context
+func NewTengoContext(ctx context.Context) *TengoContext {
+	return &TengoContext{
+		Context: ctx,
+	}
+}
+
+// IndexGet 暴露 err、done、value、deadline、cancel 方法
+func (c *TengoContext) IndexGet(index tengo.Object) (value tengo.Object, err error) {
+	name, ok := tengo.ToString(index)
+	if !ok {
+		return nil, tengo.ErrInvalidIndexType
+	}
+	var fn tengo.CallableFunc
+	switch name {
+	case "err":
+		fn = c.Err
+	case "done":
+		fn = c.Done
+	case "value":
+		fn = c.Value
+	case "deadline":
+		fn = c.Deadline
+	case "cancel":
+		fn = c.Cancel
+	default:
+		return tengo.UndefinedValue, nil
+	}
+	value = &tengo.UserFunction{
+		Name:  name,
+		Value: fn,
+	}
+	return value, nil
+}
+
+func (c *TengoContext) ctx() context.Context {
+	if c.Context == nil {
+		return context.Background()
+	}
+	return c.Context
+}
+
+// Err 上下文未结束时返回 undefined,否则返回 error 对象
+func (c *TengoContext) Err(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 0 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	ctxErr := c.ctx().Err()
+	if ctxErr == nil {
+		return tengo.UndefinedValue, nil
+	}
+	ret = &tengo.Error{Value: &tengo.String{Value: ctxErr.Error()}}
+	return ret, nil
+}
+
+// Done 上下文是否已结束(取消或超时),不阻塞
+func (c *TengoContext) Done(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 0 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	select {
+	case <-c.ctx().Done():
+		return tengo.TrueValue, nil
+	default:
+		return tengo.FalseValue, nil
+	}
+}
+
+// Value 依次按 ContextKey、string 类型的键查找值
+func (c *TengoContext) Value(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	key, ok := tengo.ToString(args[0])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "key",
+			Expected: "string",
+			Found:    args[0].TypeName(),
+		}
+	}
+	val := c.ctx().Value(ContextKey(key))
+	if val == nil {
+		val = c.ctx().Value(key)
+	}
+	ret, err = tengo.FromInterface(val)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Deadline 返回截止时间,未设置时返回 undefined
+func (c *TengoContext) Deadline(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 0 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	deadline, ok := c.ctx().Deadline()
+	if !ok {
+		return tengo.UndefinedValue, nil
+	}
+	return &tengo.Time{Value: deadline}, nil
+}
+
+// Cancel 取消由 withCancel、withTimeout、withDeadline 生成的上下文,其它上下文调用无效果
+func (c *TengoContext) Cancel(args ...tengo.Object) (ret tengo.Object, err error) {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	return nil, nil
+}
+
+// Ctx context 内置模块,current() 返回 background;内置模块在编译时固化,需要每次运行的宿主上下文时使用 AddToScript
+var Ctx = NewCtxModule(context.Background())
+
+// CTX_MODULE_NAME AddToScript 注入的 context 模块全局变量名
+const CTX_MODULE_NAME = "context"
+
+// NewCtxModule 生成 context 模块属性,current() 返回 current;作为全局变量注入(见 AddToScript),不要注册为内置模块
+func NewCtxModule(current context.Context) map[string]tengo.Object {
+	module := map[string]tengo.Object{
+		"current": &tengo.UserFunction{
+			Name:  "current",
+			Value: NewTengoContextCallable(current),
+		},
+	}
+	for k, v := range ctxModule {
+		module[k] = v
+	}
+	return module
+}
+
+var ctxModule = map[string]tengo.Object{
 	"background": &tengo.UserFunction{
 		Value: func(args ...tengo.Object) (ret tengo.Object, err error) {
 			ret = &TengoContext{
@@ -35,6 +178,149 @@ var Ctx = map[string]tengo.Object{
 			return ret, nil
 		},
 	},
+	"withCancel": &tengo.UserFunction{
+		Name:  "withCancel",
+		Value: WithCancel,
+	},
+	"withTimeout": &tengo.UserFunction{
+		Name:  "withTimeout",
+		Value: WithTimeout,
+	},
+	"withDeadline": &tengo.UserFunction{
+		Name:  "withDeadline",
+		Value: WithDeadline,
+	},
+	"withValue": &tengo.UserFunction{
+		Name:  "withValue",
+		Value: WithValue,
+	},
+}
+
+// WithCancel withCancel(parent) 返回 {ctx,cancel}
+func WithCancel(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	parent, err := toContext("parent", args[0])
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(parent)
+	return cancelPair(ctx, cancel), nil
+}
+
+// cancelPair withCancel、withTimeout、withDeadline 统一返回 {ctx,cancel}
+func cancelPair(ctx context.Context, cancel context.CancelFunc) (ret *tengo.ImmutableMap) {
+	tengoCtx := &TengoContext{Context: ctx, cancel: cancel}
+	ret = &tengo.ImmutableMap{
+		Value: map[string]tengo.Object{
+			"ctx": tengoCtx,
+			"cancel": &tengo.UserFunction{
+				Name:  "cancel",
+				Value: tengoCtx.Cancel,
+			},
+		},
+	}
+	return ret
+}
+
+// WithTimeout withTimeout(parent,"500ms") 超时时间为 time.ParseDuration 格式字符串或毫秒数,返回 {ctx,cancel},可调用 cancel() 提前释放
+func WithTimeout(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	parent, err := toContext("parent", args[0])
+	if err != nil {
+		return nil, err
+	}
+	var timeout time.Duration
+	switch o := args[1].(type) {
+	case *tengo.Int:
+		timeout = time.Duration(o.Value) * time.Millisecond
+	case *tengo.String:
+		timeout, err = time.ParseDuration(o.Value)
+		if err != nil {
+			err = errors.WithMessagef(err, "timeout:%s", o.Value)
+			return nil, err
+		}
+	default:
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "timeout",
+			Expected: "string(duration) or int(ms)",
+			Found:    args[1].TypeName(),
+		}
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	return cancelPair(ctx, cancel), nil
+}
+
+// WithDeadline withDeadline(parent,deadline) 返回 {ctx,cancel},deadline 为 time 对象、unix 秒数或 "2006-01-02 15:04:05"(本地时区)、RFC3339 格式字符串
+func WithDeadline(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	parent, err := toContext("parent", args[0])
+	if err != nil {
+		return nil, err
+	}
+	var deadline time.Time
+	switch o := args[1].(type) {
+	case *tengo.String:
+		deadline, err = time.ParseInLocation("2006-01-02 15:04:05", o.Value, time.Local)
+		if err != nil {
+			deadline, err = time.Parse(time.RFC3339, o.Value)
+		}
+		if err != nil {
+			err = errors.WithMessagef(err, "deadline:%s", o.Value)
+			return nil, err
+		}
+	default:
+		var ok bool
+		deadline, ok = tengo.ToTime(o)
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{
+				Name:     "deadline",
+				Expected: "time",
+				Found:    args[1].TypeName(),
+			}
+		}
+	}
+	ctx, cancel := context.WithDeadline(parent, deadline)
+	return cancelPair(ctx, cancel), nil
+}
+
+// WithValue withValue(parent,key,value) 键以 ContextKey 类型写入
+func WithValue(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 3 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	parent, err := toContext("parent", args[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := tengo.ToString(args[1])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "key",
+			Expected: "string",
+			Found:    args[1].TypeName(),
+		}
+	}
+	ctx := context.WithValue(parent, ContextKey(key), tengo.ToInterface(args[2]))
+	ret = &TengoContext{Context: ctx}
+	return ret, nil
+}
+
+func toContext(name string, obj tengo.Object) (ctx context.Context, err error) {
+	ctxObj, ok := obj.(*TengoContext)
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     name,
+			Expected: "context.Context",
+			Found:    obj.TypeName(),
+		}
+	}
+	return ctxObj.ctx(), nil
 }
 
 //TengoContextCallable 在tengo脚本中获取新的上下文
@@ -44,3 +330,34 @@ func TengoContextCallable(args ...tengo.Object) (ret tengo.Object, err error) {
 	}
 	return
 }
+
+// NewTengoContextCallable 在tengo脚本中获取宿主传入的上下文(携带 trace id、超时、鉴权等信息)
+func NewTengoContextCallable(ctx context.Context) tengo.CallableFunc {
+	return func(args ...tengo.Object) (ret tengo.Object, err error) {
+		ret = NewTengoContext(ctx)
+		return ret, nil
+	}
+}
+
+// AddToScript 将宿主上下文作为全局变量 name 注入脚本,并以全局变量 context 注入 context 模块,current() 返回同一上下文;
+// 脚本直接使用 context.current(),不再 import("context")。两者均为每次运行的全局变量,编译后复用或 Clone 时调用 SetToCompiled 替换
+func AddToScript(script *tengo.Script, name string, ctx context.Context) (err error) {
+	if name == CTX_MODULE_NAME {
+		err = errors.Errorf("context global name %s conflicts with the context module", name)
+		return err
+	}
+	err = script.Add(name, NewTengoContext(ctx))
+	if err != nil {
+		return err
+	}
+	return script.Add(CTX_MODULE_NAME, &tengo.ImmutableMap{Value: NewCtxModule(ctx)})
+}
+
+// SetToCompiled 替换 AddToScript 注入的全局变量为本次运行的上下文
+func SetToCompiled(compiled *tengo.Compiled, name string, ctx context.Context) (err error) {
+	err = compiled.Set(name, NewTengoContext(ctx))
+	if err != nil {
+		return err
+	}
+	return compiled.Set(CTX_MODULE_NAME, &tengo.ImmutableMap{Value: NewCtxModule(ctx)})
+}
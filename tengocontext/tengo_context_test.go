@@ -0,0 +1,45 @@
+package tengocontext
+
+import (
+	"testing"
+
+	"github.com/d5/tengo/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextModule(t *testing.T) {
+	script := tengo.NewScript([]byte(`
+	context:=import("context")
+	parent:=context.withValue(context.background(),"traceId","abc")
+	timeout:=context.withTimeout(parent,"1ms")
+	deadline:=context.withDeadline(parent,"2006-01-02 15:04:05")
+	deadlineErr:=deadline.ctx.err()
+	deadline.cancel()
+	pair:=context.withCancel(parent)
+	beforeCancel:=pair.ctx.done()
+	pair.cancel()
+	afterCancel:=pair.ctx.done()
+	cancelErr:=pair.ctx.err()
+	traceId:=pair.ctx.value("traceId")
+	noDeadline:=parent.deadline()
+	`))
+	modules := tengo.NewModuleMap()
+	modules.AddBuiltinModule("context", Ctx)
+	script.SetImports(modules)
+	c, err := script.Run()
+	require.NoError(t, err)
+	require.False(t, c.Get("beforeCancel").Bool())
+	require.True(t, c.Get("afterCancel").Bool())
+	require.Contains(t, c.Get("cancelErr").Error().Error(), "context canceled")
+	require.Equal(t, "abc", c.Get("traceId").String())
+	require.True(t, c.Get("noDeadline").IsUndefined())
+
+	require.Contains(t, c.Get("deadlineErr").Error().Error(), "deadline exceeded")
+	timeout := c.Get("timeout").Object().(*tengo.ImmutableMap)
+	timeoutCtx := timeout.Value["ctx"].(*TengoContext)
+	require.True(t, timeout.Value["cancel"].CanCall())
+	<-timeoutCtx.Context.Done()
+	ret, err := timeoutCtx.Done()
+	require.NoError(t, err)
+	require.Equal(t, tengo.TrueValue, ret)
+}
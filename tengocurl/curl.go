@@ -0,0 +1,208 @@
+package tengocurl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/logchan/v2"
+	"github.com/suifengpiao14/tengolib/tengotemplate"
+)
+
+type LogName string
+
+func (l LogName) String() string {
+	return string(l)
+}
+
+const (
+	LOG_INFO_CURL LogName = "LogInfoCurl"
+)
+
+type LogInfoCurl struct {
+	Context    context.Context
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	StatusCode int       `json:"statusCode"`
+	Err        error     `json:"error"`
+	BeginAt    time.Time `json:"beginAt"`
+	EndAt      time.Time `json:"endAt"`
+	Duration   string    `json:"time"`
+	logchan.EmptyLogInfo
+}
+
+func (l *LogInfoCurl) GetName() logchan.LogName {
+	return LOG_INFO_CURL
+}
+func (l *LogInfoCurl) Error() error {
+	return l.Err
+}
+
+// CurlConfig 资源配置
+type CurlConfig struct {
+	BaseURL string            `json:"baseURL"` // 请求行中为相对地址时拼接的基础地址,如 http://127.0.0.1:8080/api
+	Timeout string            `json:"timeout"` // 单次请求超时时间,如 "5s",空表示不限制(仍受 ctx 控制)
+	Headers map[string]string `json:"headers"` // 默认请求头,原始请求中的同名头优先
+	// MaxBodySize 响应体最大字节数,超过时返回错误,不大于 0 时使用 DefaultMaxBodySize
+	MaxBodySize int64 `json:"maxBodySize"`
+}
+
+// DefaultMaxBodySize 未配置 maxBodySize 时响应体的最大字节数
+var DefaultMaxBodySize int64 = 10 << 20
+
+func (cfg CurlConfig) maxBodySize() int64 {
+	if cfg.MaxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+	return cfg.MaxBodySize
+}
+
+// ParseTimeout 解析超时时间
+func (cfg CurlConfig) ParseTimeout() (timeout time.Duration, err error) {
+	if cfg.Timeout == "" {
+		return 0, nil
+	}
+	timeout, err = time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		err = errors.WithMessagef(err, "timeout:%s", cfg.Timeout)
+		return 0, err
+	}
+	return timeout, nil
+}
+
+// CurlResponse 响应,同名头多个值以 ", " 连接
+type CurlResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Status     string            `json:"status"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+}
+
+func (r CurlResponse) String() string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+// ParseRawRequest 解析模板输出的原始 http 请求文本:
+//
+//	POST /user HTTP/1.1
+//	Content-Type: application/json
+//
+//	{"id":1}
+//
+// 请求行与头之间换行分隔,头与体之间以 tengotemplate.HTTP_HEAD_BODY_DELIM 分隔;请求行中协议版本可省略,相对地址基于 baseURL 拼接;ctx 为 nil 时使用 context.Background()
+func ParseRawRequest(ctx context.Context, baseURL string, raw string) (req *http.Request, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	raw = strings.ReplaceAll(raw, tengotemplate.WINDOW_EOF, tengotemplate.EOF)
+	raw = strings.TrimLeft(raw, tengotemplate.EOF+" \t")
+	head, body, _ := strings.Cut(raw, tengotemplate.HTTP_HEAD_BODY_DELIM)
+	lines := strings.Split(head, tengotemplate.EOF)
+	requestLine := strings.Fields(lines[0])
+	if len(requestLine) < 2 || len(requestLine) > 3 {
+		err = errors.Errorf("invalid request line:%s", lines[0])
+		return nil, err
+	}
+	method, target := strings.ToUpper(requestLine[0]), requestLine[1]
+	reqURL, err := resolveURL(baseURL, target)
+	if err != nil {
+		return nil, err
+	}
+	req, err = http.NewRequestWithContext(ctx, method, reqURL, bytes.NewBufferString(body))
+	if err != nil {
+		err = errors.WithMessagef(err, "request line:%s", lines[0])
+		return nil, err
+	}
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			err = errors.Errorf("invalid header line:%s", line)
+			return nil, err
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if strings.EqualFold(key, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Add(key, value)
+	}
+	return req, nil
+}
+
+func resolveURL(baseURL string, target string) (reqURL string, err error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		err = errors.WithMessagef(err, "url:%s", target)
+		return "", err
+	}
+	if u.IsAbs() {
+		return target, nil
+	}
+	if baseURL == "" {
+		err = errors.Errorf("relative url %s requires baseURL", target)
+		return "", err
+	}
+	return strings.TrimRight(baseURL, "/") + "/" + strings.TrimLeft(target, "/"), nil
+}
+
+// Do 发送原始请求文本,返回响应;非 2xx 状态码不视为错误,响应体超过 maxBodySize 时返回错误
+func Do(ctx context.Context, client *http.Client, cfg CurlConfig, raw string) (resp *CurlResponse, err error) {
+	logInfo := &LogInfoCurl{Context: ctx}
+	defer func() {
+		logInfo.Err = err
+		duration := float64(logInfo.EndAt.Sub(logInfo.BeginAt).Nanoseconds()) / 1e6
+		logInfo.Duration = fmt.Sprintf("%.3fms", duration)
+		logchan.SendLogInfo(logInfo)
+	}()
+	req, err := ParseRawRequest(ctx, cfg.BaseURL, raw)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range cfg.Headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	logInfo.Method, logInfo.URL = req.Method, req.URL.String()
+	logInfo.BeginAt = time.Now().Local()
+	httpResp, err := client.Do(req)
+	logInfo.EndAt = time.Now().Local()
+	if err != nil {
+		err = errors.WithMessagef(err, "%s %s", req.Method, req.URL.String())
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	maxBodySize := cfg.maxBodySize()
+	b, err := io.ReadAll(io.LimitReader(httpResp.Body, maxBodySize+1))
+	if err != nil {
+		err = errors.WithMessagef(err, "read body:%s %s", req.Method, req.URL.String())
+		return nil, err
+	}
+	if int64(len(b)) > maxBodySize {
+		err = errors.Errorf("response body exceeds maxBodySize %d:%s %s", maxBodySize, req.Method, req.URL.String())
+		return nil, err
+	}
+	logInfo.StatusCode = httpResp.StatusCode
+	resp = &CurlResponse{
+		StatusCode: httpResp.StatusCode,
+		Status:     httpResp.Status,
+		Headers:    make(map[string]string, len(httpResp.Header)),
+		Body:       string(b),
+	}
+	for k, v := range httpResp.Header {
+		resp.Headers[k] = strings.Join(v, ", ")
+	}
+	return resp, nil
+}
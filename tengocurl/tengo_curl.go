@@ -0,0 +1,141 @@
+package tengocurl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/d5/tengo/v2"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/tengolib/tengocontext"
+	"github.com/suifengpiao14/tengolib/tengosource"
+)
+
+// TengoCurl http 资源,脚本中通过 execOrQueryContext(ctx,rawRequest) 发送模板渲染出的原始请求
+type TengoCurl struct {
+	tengo.ImmutableMap
+	client *http.Client
+	config CurlConfig
+}
+
+func (tengoCurl *TengoCurl) TypeName() string {
+	return "curl"
+}
+func (tengoCurl *TengoCurl) String() string {
+	return ""
+}
+
+// init 导入本包即注册 CURL 资源类型
+func init() {
+	tengosource.RegisterProviderFactory(tengosource.PROVIDER_CURL, func(config string) (tengosource.Provider, error) {
+		return NewTengoCurl(config)
+	})
+}
+
+// NewTengoCurl config 为 CurlConfig 的 json 格式,可为空
+func NewTengoCurl(config string) (tengoCurl *TengoCurl, err error) {
+	cfg := CurlConfig{}
+	if config != "" {
+		err = json.Unmarshal([]byte(config), &cfg)
+		if err != nil {
+			err = errors.WithMessagef(err, "curl config:%s", config)
+			return nil, err
+		}
+	}
+	timeout, err := cfg.ParseTimeout()
+	if err != nil {
+		return nil, err
+	}
+	tengoCurl = &TengoCurl{
+		ImmutableMap: tengo.ImmutableMap{
+			Value: make(map[string]tengo.Object),
+		},
+		client: &http.Client{Timeout: timeout},
+		config: cfg,
+	}
+	//注入tengo 脚本方法
+	methods := map[string]tengo.CallableFunc{
+		"execOrQueryContext": tengoCurl.TengoExecOrQueryContext,
+	}
+	for key, method := range methods {
+		tengoCurl.Value[key] = &tengo.UserFunction{
+			Name:  key,
+			Value: method,
+		}
+	}
+	return tengoCurl, nil
+}
+
+// SetClient 替换 http 客户端(如设置代理、测试桩),客户端 Timeout 为 0 时保留配置中的超时时间;使用副本,不修改传入的客户端
+func (tengoCurl *TengoCurl) SetClient(client *http.Client) {
+	copied := *client
+	if copied.Timeout == 0 {
+		copied.Timeout = tengoCurl.client.Timeout
+	}
+	tengoCurl.client = &copied
+}
+
+// Close 关闭空闲连接
+func (tengoCurl *TengoCurl) Close() (err error) {
+	tengoCurl.client.CloseIdleConnections()
+	return nil
+}
+
+// Health http 资源无常驻连接,不主动探测,始终返回 nil
+func (tengoCurl *TengoCurl) Health(ctx context.Context) (err error) {
+	return nil
+}
+
+// Do 发送原始请求文本,返回完整响应
+func (tengoCurl *TengoCurl) Do(ctx context.Context, rawRequest string) (resp *CurlResponse, err error) {
+	return Do(ctx, tengoCurl.client, tengoCurl.config, rawRequest)
+}
+
+// ExecOrQueryContext 与 TengoDB 签名一致,返回响应的 json 格式(statusCode、status、headers、body)
+func (tengoCurl *TengoCurl) ExecOrQueryContext(ctx context.Context, rawRequest string) (out string, err error) {
+	resp, err := tengoCurl.Do(ctx, rawRequest)
+	if err != nil {
+		return "", err
+	}
+	return resp.String(), nil
+}
+
+// TengoExecOrQueryContext 返回 {statusCode,status,headers,body}
+func (tengoCurl *TengoCurl) TengoExecOrQueryContext(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	ctxObj, ok := args[0].(*tengocontext.TengoContext)
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "context",
+			Expected: "context.Context",
+			Found:    args[0].TypeName(),
+		}
+	}
+	rawRequest, ok := tengo.ToString(args[1])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "rawRequest",
+			Expected: "string",
+			Found:    args[1].TypeName(),
+		}
+	}
+	resp, err := tengoCurl.Do(ctxObj.Context, rawRequest)
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]tengo.Object, len(resp.Headers))
+	for k, v := range resp.Headers {
+		headers[k] = &tengo.String{Value: v}
+	}
+	ret = &tengo.ImmutableMap{
+		Value: map[string]tengo.Object{
+			"statusCode": &tengo.Int{Value: int64(resp.StatusCode)},
+			"status":     &tengo.String{Value: resp.Status},
+			"headers":    &tengo.ImmutableMap{Value: headers},
+			"body":       &tengo.String{Value: resp.Body},
+		},
+	}
+	return ret, nil
+}
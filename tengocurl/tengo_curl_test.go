@@ -0,0 +1,79 @@
+package tengocurl
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/d5/tengo/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/tengolib/tengocontext"
+)
+
+func TestTengoCurl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Token", r.Header.Get("X-Token"))
+		w.Header().Set("X-App", r.Header.Get("X-App"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(r.URL.RequestURI() + "|" + string(b)))
+	}))
+	defer server.Close()
+
+	tengoCurl, err := NewTengoCurl(`{"baseURL":"` + server.URL + `/api","timeout":"1s","headers":{"X-Token":"default","X-App":"tengolib"}}`)
+	require.NoError(t, err)
+	raw := "post /user?id=1 HTTP/1.1\r\nContent-Type: application/json\r\nX-Token: abc\r\n\r\n{\"name\":\"tom\"}"
+	resp, err := tengoCurl.Do(context.Background(), raw)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, "POST", resp.Headers["X-Method"])
+	require.Equal(t, "abc", resp.Headers["X-Token"])
+	require.Equal(t, "tengolib", resp.Headers["X-App"])
+	require.Equal(t, `/api/user?id=1|{"name":"tom"}`, resp.Body)
+
+	script := tengo.NewScript([]byte(`resp:=curl.execOrQueryContext(ctx,"GET ` + server.URL + `/ping")`))
+	require.NoError(t, script.Add("curl", tengoCurl))
+	require.NoError(t, script.Add("ctx", tengocontext.NewTengoContext(context.Background())))
+	c, err := script.Run()
+	require.NoError(t, err)
+	out := tengo.ToInterface(c.Get("resp").Object()).(map[string]interface{})
+	require.Equal(t, int64(http.StatusCreated), out["statusCode"])
+	require.Equal(t, "/ping|", out["body"])
+
+	client := &http.Client{}
+	tengoCurl.SetClient(client)
+	require.Zero(t, client.Timeout)
+	resp, err = tengoCurl.Do(context.Background(), "GET "+server.URL+"/ping")
+	require.NoError(t, err)
+	require.Equal(t, "/ping|", resp.Body)
+
+	_, err = tengoCurl.Do(context.Background(), "GET")
+	require.Error(t, err)
+	_, err = NewTengoCurl(`{"timeout":"1x"}`)
+	require.Error(t, err)
+}
+
+func TestTengoCurlLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	tengoCurl, err := NewTengoCurl(`{"maxBodySize":10}`)
+	require.NoError(t, err)
+	script := tengo.NewScript([]byte(`resp:=curl.execOrQueryContext(ctx,"GET ` + server.URL + `")`))
+	require.NoError(t, script.Add("curl", tengoCurl))
+	require.NoError(t, script.Add("ctx", &tengocontext.TengoContext{}))
+	c, err := script.Run()
+	require.NoError(t, err)
+	out := tengo.ToInterface(c.Get("resp").Object()).(map[string]interface{})
+	require.Equal(t, "0123456789", out["body"])
+
+	tengoCurl, err = NewTengoCurl(`{"maxBodySize":9}`)
+	require.NoError(t, err)
+	_, err = tengoCurl.Do(context.Background(), "GET "+server.URL)
+	require.ErrorContains(t, err, "maxBodySize")
+}
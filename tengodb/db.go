@@ -5,17 +5,61 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 	"github.com/suifengpiao14/logchan/v2"
 	"github.com/suifengpiao14/tengolib/tengotemplate"
 	"github.com/suifengpiao14/tengolib/util"
 )
 
 type DBConfig struct {
-	DSN string `json:"dsn"`
+	DSN             string `json:"dsn"`
+	Driver          string `json:"driver"`          // mysql(默认)、postgres、sqlite3 等,除 mysql 外需在外部导入对应驱动
+	MaxOpenConns    int    `json:"maxOpenConns"`    // 最大连接数,0 表示不限制
+	MaxIdleConns    int    `json:"maxIdleConns"`    // 最大空闲连接数,0 表示使用 database/sql 默认值
+	ConnMaxLifetime string `json:"connMaxLifetime"` // 连接最长存活时间,如 "1h",空表示不限制
+	ConnMaxIdleTime string `json:"connMaxIdleTime"` // 连接最长空闲时间,如 "10m",空表示不限制
+	PingOnOpen      bool   `json:"pingOnOpen"`      // 打开后立即 ping,使错误的 dsn 在创建资源时就暴露
+}
+
+// ApplyPool 设置连接池参数
+func (cfg DBConfig) ApplyPool(db *sql.DB) (err error) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime != "" {
+		d, err := time.ParseDuration(cfg.ConnMaxLifetime)
+		if err != nil {
+			err = errors.WithMessagef(err, "connMaxLifetime:%s", cfg.ConnMaxLifetime)
+			return err
+		}
+		db.SetConnMaxLifetime(d)
+	}
+	if cfg.ConnMaxIdleTime != "" {
+		d, err := time.ParseDuration(cfg.ConnMaxIdleTime)
+		if err != nil {
+			err = errors.WithMessagef(err, "connMaxIdleTime:%s", cfg.ConnMaxIdleTime)
+			return err
+		}
+		db.SetConnMaxIdleTime(d)
+	}
+	return nil
+}
+
+// DriverName 获取驱动名称,未配置时使用包变量 DriverName
+func (cfg DBConfig) DriverName() string {
+	if cfg.Driver != "" {
+		return cfg.Driver
+	}
+	return DriverName
 }
 
 type LogName string
@@ -27,6 +71,7 @@ func (l LogName) String() string {
 type LogInfoEXECSQL struct {
 	Context      context.Context
 	SQL          string    `json:"sql"`
+	Args         []any     `json:"args"`
 	Result       string    `json:"result"`
 	Err          error     `json:"error"`
 	BeginAt      time.Time `json:"beginAt"`
@@ -47,11 +92,11 @@ const (
 	LOG_INFO_EXEC_SQL LogName = "LogInfoEXECSQL"
 )
 
-var DriverName = "mysql"
+var DriverName = tengotemplate.DRIVER_MYSQL
 
 const (
-	SQL_TYPE_SELECT = "SELECT"
-	SQL_TYPE_OTHER  = "OTHER"
+	SQL_TYPE_SELECT = tengotemplate.SQL_TYPE_SELECT
+	SQL_TYPE_OTHER  = tengotemplate.SQL_TYPE_OTHER
 )
 
 type any = interface{}
@@ -60,7 +105,37 @@ type ExectorInterface interface {
 	QueryContext(ctx context.Context, query string, args ...any) (rows *sql.Rows, err error)
 }
 
+// NormalizeSQL 格式化sql语句,执行、日志及录制均使用该格式;合并空白前去除 -- 行注释,避免换行合并后注释吞掉后续语句
+func NormalizeSQL(sqls string) string {
+	return util.StandardizeSpaces(util.TrimSpaces(stripLineComments(sqls)))
+}
+
+// stripLineComments 去除引号、块注释、$tag$ 之外的 -- 行注释,保留换行
+func stripLineComments(sqls string) string {
+	if !strings.Contains(sqls, "--") {
+		return sqls
+	}
+	var w strings.Builder
+	for i := 0; i < len(sqls); {
+		if j := skipSQLLiteral(sqls, i); j > i {
+			if !strings.HasPrefix(sqls[i:], "--") {
+				w.WriteString(sqls[i:j])
+			}
+			i = j
+			continue
+		}
+		w.WriteByte(sqls[i])
+		i++
+	}
+	return w.String()
+}
+
 func ExecOrQueryContext(ctx context.Context, exetor ExectorInterface, sqls string) (out string, err error) {
+	return ExecOrQueryArgsContext(ctx, exetor, sqls)
+}
+
+// ExecOrQueryArgsContext 执行带 ? 占位符的语句,参数交由驱动绑定,不再内联到sql中
+func ExecOrQueryArgsContext(ctx context.Context, exetor ExectorInterface, sqls string, args ...any) (out string, err error) {
 	sqlLogInfo := &LogInfoEXECSQL{}
 	defer func() {
 		sqlLogInfo.Err = err
@@ -68,12 +143,14 @@ func ExecOrQueryContext(ctx context.Context, exetor ExectorInterface, sqls strin
 		sqlLogInfo.Duration = fmt.Sprintf("%.3fms", duration)
 		logchan.SendLogInfo(sqlLogInfo)
 	}()
-	sqls = util.StandardizeSpaces(util.TrimSpaces(sqls)) // 格式化sql语句
+	sqls = NormalizeSQL(sqls) // 格式化sql语句
+	sqlLogInfo.Context = ctx
 	sqlLogInfo.SQL = sqls
+	sqlLogInfo.Args = args
 	sqlType := SQLType(sqls)
 	if sqlType != SQL_TYPE_SELECT {
 		sqlLogInfo.BeginAt = time.Now().Local()
-		res, err := exetor.ExecContext(ctx, sqls)
+		res, err := exetor.ExecContext(ctx, sqls, args...)
 		if err != nil {
 			return "", err
 		}
@@ -87,7 +164,7 @@ func ExecOrQueryContext(ctx context.Context, exetor ExectorInterface, sqls strin
 		return strconv.FormatInt(rowsAffected, 10), nil
 	}
 	sqlLogInfo.BeginAt = time.Now().Local()
-	rows, err := exetor.QueryContext(ctx, sqls)
+	rows, err := exetor.QueryContext(ctx, sqls, args...)
 	sqlLogInfo.EndAt = time.Now().Local()
 	if err != nil {
 		return "", err
@@ -155,6 +232,416 @@ func ExecOrQueryContext(ctx context.Context, exetor ExectorInterface, sqls strin
 	return out, nil
 }
 
+// ExecOrQueryNamedContext 将命名sql转换为驱动对应的占位符语句并绑定参数执行;多条语句(以;分隔)逐条绑定执行,参数分配同 ExecContextByDriver,返回最后一条语句的输出
+func ExecOrQueryNamedContext(ctx context.Context, exetor ExectorInterface, driverName string, named string, data map[string]any) (out string, err error) {
+	statement, arguments, err := tengotemplate.ToNamedSQLByDriver(driverName, named, data)
+	if err != nil {
+		return "", err
+	}
+	statements := SplitStatements(statement)
+	if len(statements) <= 1 {
+		return ExecOrQueryArgsContext(ctx, exetor, statement, arguments...)
+	}
+	bindType := tengotemplate.GetDialect(driverName).BindType
+	used := 0
+	for _, statement := range statements {
+		var stmtArgs []any
+		statement, stmtArgs, used, err = bindStatement(bindType, statement, arguments, used)
+		if err != nil {
+			return "", err
+		}
+		out, err = ExecOrQueryArgsContext(ctx, exetor, statement, stmtArgs...)
+		if err != nil {
+			err = errors.WithMessagef(err, "statement:%s", statement)
+			return "", err
+		}
+	}
+	if used < len(arguments) {
+		err = errors.Errorf("too many args, %d unused", len(arguments)-used)
+		return "", err
+	}
+	return out, nil
+}
+
+// QueryContext 执行查询,根据 rows.ColumnTypes() 将列值转换为对应的go类型(int64,float64,bool,time.Time,[]byte,string,nil),只返回第一个结果集
+func QueryContext(ctx context.Context, exetor ExectorInterface, sqls string, args ...any) (records []map[string]any, err error) {
+	sqlLogInfo := &LogInfoEXECSQL{}
+	defer func() {
+		sqlLogInfo.Err = err
+		duration := float64(sqlLogInfo.EndAt.Sub(sqlLogInfo.BeginAt).Nanoseconds()) / 1e6
+		sqlLogInfo.Duration = fmt.Sprintf("%.3fms", duration)
+		logchan.SendLogInfo(sqlLogInfo)
+	}()
+	sqls = NormalizeSQL(sqls) // 格式化sql语句
+	sqlLogInfo.Context = ctx
+	sqlLogInfo.SQL = sqls
+	sqlLogInfo.Args = args
+	sqlLogInfo.BeginAt = time.Now().Local()
+	rows, err := exetor.QueryContext(ctx, sqls, args...)
+	sqlLogInfo.EndAt = time.Now().Local()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	records = make([]map[string]any, 0)
+	for rows.Next() {
+		values := make([]any, len(columnTypes))
+		for i := range values {
+			values[i] = new(any)
+		}
+		if err = rows.Scan(values...); err != nil {
+			return nil, err
+		}
+		record := make(map[string]any, len(columnTypes))
+		for i, columnType := range columnTypes {
+			val, err := convertColumnValue(columnType, *(values[i].(*any)))
+			if err != nil {
+				return nil, err
+			}
+			record[columnType.Name()] = val
+		}
+		records = append(records, record)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	sqlLogInfo.AffectedRows = int64(len(records))
+	b, err := json.Marshal(records)
+	if err == nil {
+		sqlLogInfo.Result = string(b)
+	}
+	return records, nil
+}
+
+// convertColumnValue 根据数据库列类型转换驱动返回的原始值(文本协议下驱动普遍返回[]byte)
+func convertColumnValue(columnType *sql.ColumnType, src any) (dst any, err error) {
+	if src == nil {
+		return nil, nil
+	}
+	typeName := strings.ToUpper(columnType.DatabaseTypeName())
+	switch typeName {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "YEAR",
+		"UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED INT", "UNSIGNED BIGINT",
+		"INT2", "INT4", "INT8", "SERIAL", "BIGSERIAL":
+		switch v := src.(type) {
+		case int64:
+			return v, nil
+		case uint64:
+			if v > math.MaxInt64 {
+				return strconv.FormatUint(v, 10), nil // 超出 int64 范围的无符号值以字符串返回
+			}
+			return int64(v), nil
+		case []byte:
+			return parseIntColumn(string(v))
+		case string:
+			return parseIntColumn(v)
+		}
+	case "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8":
+		switch v := src.(type) {
+		case float64:
+			return v, nil
+		case float32:
+			return float64(v), nil
+		case []byte:
+			return strconv.ParseFloat(string(v), 64)
+		case string:
+			return strconv.ParseFloat(v, 64)
+		}
+	case "BOOL", "BOOLEAN":
+		switch v := src.(type) {
+		case bool:
+			return v, nil
+		case int64:
+			return v != 0, nil
+		case []byte:
+			return strconv.ParseBool(string(v))
+		case string:
+			return strconv.ParseBool(v)
+		}
+	case "DATE", "DATETIME", "TIMESTAMP", "TIMESTAMPTZ", "TIMESTAMP WITH TIME ZONE", "TIMESTAMP WITHOUT TIME ZONE":
+		switch v := src.(type) {
+		case time.Time:
+			return v, nil
+		case []byte:
+			return parseTimeColumn(string(v)), nil
+		case string:
+			return parseTimeColumn(v), nil
+		}
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "BYTEA", "BIT", "GEOMETRY":
+		switch v := src.(type) {
+		case []byte:
+			return append([]byte(nil), v...), nil
+		}
+	}
+	switch v := src.(type) {
+	case []byte: // DECIMAL 等保留字符串,避免精度丢失
+		return string(v), nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case float32:
+		return float64(v), nil
+	}
+	return src, nil
+}
+
+// parseIntColumn 超出 int64 范围的无符号值以字符串返回
+func parseIntColumn(s string) (dst any, err error) {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return i, nil
+	}
+	if _, uintErr := strconv.ParseUint(s, 10, 64); uintErr == nil {
+		return s, nil
+	}
+	return nil, err
+}
+
+// 日期列文本格式,未开启 parseTime 的 mysql 及 sqlite 以文本返回
+var timeColumnLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+// parseTimeColumn 按本地时区解析日期列,无法解析(如 0000-00-00)时保留字符串
+func parseTimeColumn(s string) (dst any) {
+	for _, layout := range timeColumnLayouts {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t
+		}
+	}
+	return s
+}
+
+// ExecResult 单条非查询语句的执行结果,lastInsertId 与 rowsAffected 分开返回
+type ExecResult struct {
+	LastInsertId int64 `json:"lastInsertId"`
+	RowsAffected int64 `json:"rowsAffected"`
+}
+
+// ExecContext 执行非查询语句,多条语句(以;分隔)逐条执行并返回每条语句的结果;占位符格式取包变量 DriverName 对应的方言,见 ExecContextByDriver
+func ExecContext(ctx context.Context, exetor ExectorInterface, sqls string, args ...any) (results []ExecResult, err error) {
+	return ExecContextByDriver(ctx, exetor, DriverName, sqls, args...)
+}
+
+// ExecContextByDriver 同 ExecContext,args 按驱动方言分配:? 按各语句中的数量依次分配;$N 在整段sql中全局编号(同 sqlx.Rebind 的输出),执行时每条语句重新从 $1 编号
+func ExecContextByDriver(ctx context.Context, exetor ExectorInterface, driverName string, sqls string, args ...any) (results []ExecResult, err error) {
+	sqlLogInfo := &LogInfoEXECSQL{}
+	defer func() {
+		sqlLogInfo.Err = err
+		duration := float64(sqlLogInfo.EndAt.Sub(sqlLogInfo.BeginAt).Nanoseconds()) / 1e6
+		sqlLogInfo.Duration = fmt.Sprintf("%.3fms", duration)
+		logchan.SendLogInfo(sqlLogInfo)
+	}()
+	sqls = NormalizeSQL(sqls) // 格式化sql语句
+	sqlLogInfo.Context = ctx
+	sqlLogInfo.SQL = sqls
+	sqlLogInfo.Args = args
+	statements := SplitStatements(sqls)
+	results = make([]ExecResult, 0, len(statements))
+	sqlLogInfo.BeginAt = time.Now().Local()
+	defer func() {
+		sqlLogInfo.EndAt = time.Now().Local()
+	}()
+	bindType := tengotemplate.GetDialect(driverName).BindType
+	used := 0
+	for _, statement := range statements {
+		var stmtArgs []any
+		statement, stmtArgs, used, err = bindStatement(bindType, statement, args, used)
+		if err != nil {
+			return nil, err
+		}
+		res, err := exetor.ExecContext(ctx, statement, stmtArgs...)
+		if err != nil {
+			err = errors.WithMessagef(err, "statement:%s", statement)
+			return nil, err
+		}
+		result := ExecResult{}
+		result.LastInsertId, _ = res.LastInsertId()
+		result.RowsAffected, _ = res.RowsAffected()
+		sqlLogInfo.AffectedRows += result.RowsAffected
+		results = append(results, result)
+	}
+	if used < len(args) {
+		err = errors.Errorf("too many args, %d unused", len(args)-used)
+		return nil, err
+	}
+	b, err := json.Marshal(results)
+	if err == nil {
+		sqlLogInfo.Result = string(b)
+	}
+	return results, nil
+}
+
+// SplitStatements 以;拆分多条sql语句,忽略引号、注释(-- 、/* */)及 postgres $tag$ 包围的内容(如函数体)中的;
+func SplitStatements(sqls string) (statements []string) {
+	statements = make([]string, 0)
+	start := 0
+	for i := 0; i < len(sqls); {
+		if j := skipSQLLiteral(sqls, i); j > i {
+			i = j
+			continue
+		}
+		if sqls[i] == ';' {
+			if statement := util.TrimSpaces(sqls[start:i]); statement != "" {
+				statements = append(statements, statement)
+			}
+			start = i + 1
+		}
+		i++
+	}
+	if statement := util.TrimSpaces(sqls[start:]); statement != "" {
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+// skipSQLLiteral i 处为引号字符串、注释或 $tag$ 包围的内容时返回其结束位置(-- 注释不含换行),否则返回 i;未闭合时返回 len(sqls)
+func skipSQLLiteral(sqls string, i int) (next int) {
+	c := sqls[i]
+	switch {
+	case c == '\'' || c == '"' || c == '`':
+		for j := i + 1; j < len(sqls); j++ {
+			switch sqls[j] {
+			case '\\':
+				j++
+			case c:
+				return j + 1
+			}
+		}
+		return len(sqls)
+	case strings.HasPrefix(sqls[i:], "--"):
+		if j := strings.IndexByte(sqls[i:], '\n'); j >= 0 {
+			return i + j
+		}
+		return len(sqls)
+	case strings.HasPrefix(sqls[i:], "/*"):
+		if j := strings.Index(sqls[i+2:], "*/"); j >= 0 {
+			return i + 2 + j + 2
+		}
+		return len(sqls)
+	case c == '$':
+		tag := dollarQuoteTag(sqls, i)
+		if tag == "" {
+			return i
+		}
+		if j := strings.Index(sqls[i+len(tag):], tag); j >= 0 {
+			return i + len(tag) + j + len(tag)
+		}
+		return len(sqls)
+	}
+	return i
+}
+
+// dollarQuoteTag 返回 i 处 postgres 美元引号的标记($$、$body$),$1 等占位符及标识符中的 $ 返回空
+func dollarQuoteTag(sqls string, i int) (tag string) {
+	if i > 0 && isIdentByte(sqls[i-1]) {
+		return ""
+	}
+	j := i + 1
+	for j < len(sqls) && isIdentByte(sqls[j]) && sqls[j] != '$' && !(j == i+1 && sqls[j] >= '0' && sqls[j] <= '9') {
+		j++
+	}
+	if j < len(sqls) && sqls[j] == '$' {
+		return sqls[i : j+1]
+	}
+	return ""
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// countPlaceholders 统计引号、注释、$tag$ 之外 ? 占位符数量
+func countPlaceholders(statement string) (count int) {
+	for i := 0; i < len(statement); {
+		if j := skipSQLLiteral(statement, i); j > i {
+			i = j
+			continue
+		}
+		if statement[i] == '?' {
+			count++
+		}
+		i++
+	}
+	return count
+}
+
+// bindStatement 取出语句使用的参数,used 为此前语句已使用的参数数量;
+// ? 依次消耗参数;$N 引用整段sql中第 N 个参数,返回的语句重新从 $1 编号
+func bindStatement(bindType int, statement string, args []any, used int) (stmt string, stmtArgs []any, nextUsed int, err error) {
+	if bindType != sqlx.DOLLAR {
+		count := countPlaceholders(statement)
+		if used+count > len(args) {
+			err = errors.Errorf("not enough args for statement:%s, want %d, have %d", statement, count, len(args)-used)
+			return "", nil, used, err
+		}
+		return statement, args[used : used+count], used + count, nil
+	}
+	numbers := dollarPlaceholders(statement)
+	local := make(map[int]int, len(numbers))
+	nextUsed = used
+	for _, n := range numbers {
+		if n > len(args) {
+			err = errors.Errorf("not enough args for statement:%s, want $%d, have %d", statement, n, len(args))
+			return "", nil, used, err
+		}
+		if _, ok := local[n]; !ok {
+			local[n] = len(stmtArgs) + 1
+			stmtArgs = append(stmtArgs, args[n-1])
+		}
+		if n > nextUsed {
+			nextUsed = n
+		}
+	}
+	stmt = replaceDollarPlaceholders(statement, func(n int) int { return local[n] })
+	return stmt, stmtArgs, nextUsed, nil
+}
+
+// dollarPlaceholders 按出现顺序返回引号、注释、$tag$ 之外 $N 占位符的序号
+func dollarPlaceholders(statement string) (numbers []int) {
+	replaceDollarPlaceholders(statement, func(n int) int {
+		numbers = append(numbers, n)
+		return n
+	})
+	return numbers
+}
+
+// replaceDollarPlaceholders 将引号、注释、$tag$ 之外的 $N 替换为 $fn(N)
+func replaceDollarPlaceholders(statement string, fn func(n int) int) string {
+	var w strings.Builder
+	for i := 0; i < len(statement); {
+		if j := skipSQLLiteral(statement, i); j > i {
+			w.WriteString(statement[i:j])
+			i = j
+			continue
+		}
+		c := statement[i]
+		if c == '$' {
+			j := i + 1
+			for j < len(statement) && statement[j] >= '0' && statement[j] <= '9' {
+				j++
+			}
+			if j > i+1 {
+				n, _ := strconv.Atoi(statement[i+1 : j])
+				w.WriteString("$" + strconv.Itoa(fn(n)))
+				i = j
+				continue
+			}
+		}
+		w.WriteByte(c)
+		i++
+	}
+	return w.String()
+}
+
 // MapScan copy sqlx
 func MapScan(r *sql.Rows, dest map[string]interface{}) error {
 	// ignore r.started, since we needn't use reflect for anything.
@@ -182,16 +669,5 @@ func MapScan(r *sql.Rows, dest map[string]interface{}) error {
 
 // SQLType 判断 sql  属于那种类型
 func SQLType(sqls string) string {
-	sqlArr := strings.Split(sqls, tengotemplate.EOF)
-	selectLen := len(SQL_TYPE_SELECT)
-	for _, sql := range sqlArr {
-		if len(sql) < selectLen {
-			continue
-		}
-		pre := sql[:selectLen]
-		if strings.ToUpper(pre) == SQL_TYPE_SELECT {
-			return SQL_TYPE_SELECT
-		}
-	}
-	return SQL_TYPE_OTHER
+	return tengotemplate.SQLType(sqls)
 }
@@ -1,8 +1,171 @@
 package tengodb
 
 import (
-    _ "embed"
+	"context"
+	_ "embed"
+	"fmt"
+	"sync"
+
+	"github.com/d5/tengo/v2"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/tengolib/tengocontext"
 )
 
 //go:embed db_transaction.tengo
 var TengoDBSource string
+
+// Transaction 供 Go 调用:db 为 TengoDB、TengoTx 等带 beginTx 方法的对象,fn 返回 error(含 error 对象)或 panic 时回滚,否则提交;提交失败返回错误,panic 回滚后继续抛出
+func Transaction(ctx context.Context, db tengo.Object, fn func(tx tengo.Object) (ret tengo.Object, err error)) (ret tengo.Object, err error) {
+	beginTx := getMember(db, "beginTx")
+	if beginTx == nil {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "db",
+			Expected: "object with beginTx",
+			Found:    db.TypeName(),
+		}
+	}
+	tx, err := beginTx.Call(tengocontext.NewTengoContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	finished := false
+	defer func() {
+		if finished {
+			return
+		}
+		r := recover()
+		_ = rollbackTx(tx)
+		panic(r)
+	}()
+	ret, err = fn(tx)
+	finished = true
+	if err != nil {
+		rollbackErr := rollbackTx(tx)
+		if rollbackErr != nil {
+			err = errors.WithMessagef(err, "rollback:%s", rollbackErr.Error())
+		}
+		return nil, err
+	}
+	ret, err = FinishTx(tx, ret)
+	if err != nil {
+		return nil, err
+	}
+	if errObj, ok := ret.(*tengo.Error); ok {
+		return nil, errors.New(errObj.Value.String())
+	}
+	return ret, nil
+}
+
+// FinishTx 结束 db.Transaction 开启的事务:result 为 error 对象时回滚并原样返回,否则提交并返回 result;提交失败以 error 对象返回,回滚失败返回错误
+func FinishTx(tx tengo.Object, result tengo.Object) (ret tengo.Object, err error) {
+	if _, ok := result.(*tengo.Error); ok {
+		err = rollbackTx(tx)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	commit := getMember(tx, "commit")
+	if commit == nil {
+		return nil, errors.Errorf("%s has no commit method", tx.TypeName())
+	}
+	_, err = commit.Call()
+	if err != nil {
+		ret = &tengo.Error{Value: &tengo.String{Value: fmt.Sprintf("commit: %s", err.Error())}}
+		return ret, nil
+	}
+	return result, nil
+}
+
+// TengoFinishTx 事务对象的 finish(result) 方法,见 FinishTx
+func TengoFinishTx(tx tengo.Object) tengo.CallableFunc {
+	return func(args ...tengo.Object) (ret tengo.Object, err error) {
+		if len(args) != 1 {
+			return nil, tengo.ErrWrongNumArguments
+		}
+		return FinishTx(tx, args[0])
+	}
+}
+
+func rollbackTx(tx tengo.Object) (err error) {
+	rollback := getMember(tx, "rollback")
+	if rollback == nil {
+		return errors.Errorf("%s has no rollback method", tx.TypeName())
+	}
+	_, err = rollback.Call()
+	return err
+}
+
+func getMember(obj tengo.Object, name string) tengo.Object {
+	member, err := obj.IndexGet(&tengo.String{Value: name})
+	if err != nil || member == nil || !member.CanCall() {
+		return nil
+	}
+	return member
+}
+
+// TxTracker 记录一次脚本运行中开启且尚未结束的顶级事务。tengo 无法在 Go 中调用脚本闭包,db.Transaction 的 fn 发生运行时错误(如数组越界)时脚本直接中断,
+// 宿主在 script.Run 返回后调用 RollbackOpen 回滚遗留事务并归还连接,不依赖 ctx 结束
+type TxTracker struct {
+	txs  []tengo.Object
+	lock sync.Mutex
+}
+
+type txTrackerKey struct{}
+
+// WithTxTracker 返回携带 TxTracker 的 ctx,脚本使用该 ctx(含其派生 ctx)开启的事务均会被记录
+func WithTxTracker(ctx context.Context) (context.Context, *TxTracker) {
+	tracker := &TxTracker{}
+	return context.WithValue(ctx, txTrackerKey{}, tracker), tracker
+}
+
+// txTrackerFrom 获取 ctx 中的 TxTracker,未设置时返回 nil
+func txTrackerFrom(ctx context.Context) *TxTracker {
+	if ctx == nil {
+		return nil
+	}
+	tracker, _ := ctx.Value(txTrackerKey{}).(*TxTracker)
+	return tracker
+}
+
+func (t *TxTracker) add(tx tengo.Object) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.txs = append(t.txs, tx)
+}
+
+func (t *TxTracker) remove(tx tengo.Object) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for i, open := range t.txs {
+		if open == tx {
+			t.txs = append(t.txs[:i], t.txs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Open 尚未提交或回滚的事务
+func (t *TxTracker) Open() (txs []tengo.Object) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	txs = make([]tengo.Object, len(t.txs))
+	copy(txs, t.txs)
+	return txs
+}
+
+// RollbackOpen 回滚所有未结束的事务,返回第一个回滚错误
+func (t *TxTracker) RollbackOpen() (err error) {
+	for _, tx := range t.Open() {
+		if rollbackErr := rollbackTx(tx); rollbackErr != nil && err == nil {
+			err = rollbackErr
+		}
+	}
+	return err
+}
@@ -3,15 +3,18 @@ package tengodb
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/d5/tengo/v2"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/pkg/errors"
 	"github.com/suifengpiao14/tengolib/tengocontext"
+	"github.com/suifengpiao14/tengolib/tengotemplate"
 )
 
-// TengoDBInterface 为了实现 memory_db 替换,改成接口，对 事务没有实现替换，后续可以扩展memory_db 时，加上事务方法
+// TengoDBInterface 为了实现 memory_db 替换,改成接口,beginTx 返回的事务对象在两种实现中提供相同的脚本方法
 type TengoDBInterface interface {
 	tengo.Object
 	ExecOrQueryContext(ctx context.Context, sql string) (out string, err error)
@@ -19,7 +22,10 @@ type TengoDBInterface interface {
 
 type TengoDB struct {
 	tengo.ImmutableMap
-	sqlDB *sql.DB
+	sqlDB       *sql.DB
+	driverName  string
+	registry    *TengoDBRegistry // 由注册表创建时记录,关闭时释放引用
+	registryKey string
 }
 
 func (tengoDB *TengoDB) TypeName() string {
@@ -33,35 +39,78 @@ func (tengoDB *TengoDB) GetDB() (db *sql.DB) {
 	return tengoDB.sqlDB
 }
 
-var tengoDBMap = make(map[string]*TengoDB)
+func (tengoDB *TengoDB) DriverName() string {
+	return tengoDB.driverName
+}
 
-func NewTengoDB(config string) (tengoDB *TengoDB, err error) {
-	tengoDB, ok := tengoDBMap[config]
-	if ok {
-		return tengoDB, nil
+// Close 关闭连接池;由注册表创建的实例释放一个引用,最后一个引用释放时才移出缓存并关闭连接池
+func (tengoDB *TengoDB) Close() (err error) {
+	if tengoDB.registry != nil && !tengoDB.registry.release(tengoDB.registryKey, tengoDB) {
+		return nil
+	}
+	return tengoDB.sqlDB.Close()
+}
+
+// Health ping 数据库
+func (tengoDB *TengoDB) Health(ctx context.Context) (err error) {
+	err = tengoDB.sqlDB.PingContext(ctx)
+	if err != nil {
+		err = errors.WithMessage(err, "ping db")
+		return err
 	}
+	return nil
+}
+
+var tengoDBRegistry = NewTengoDBRegistry()
+
+// PingOnOpenTimeout pingOnOpen 的超时时间,避免不可达的 dsn 长时间阻塞创建
+var PingOnOpenTimeout = 5 * time.Second
+
+// NewTengoDB 从默认注册表获取实例,语义相同的配置共享同一个连接池
+func NewTengoDB(config string) (tengoDB *TengoDB, err error) {
+	return tengoDBRegistry.Get(config)
+}
+
+// CloseTengoDB 忽略引用计数,强制关闭并移除默认注册表中配置对应的实例
+func CloseTengoDB(config string) (err error) {
+	return tengoDBRegistry.Close(config)
+}
+
+// CloseAllTengoDB 忽略引用计数,强制关闭并移除默认注册表中所有实例
+func CloseAllTengoDB() (err error) {
+	return tengoDBRegistry.CloseAll()
+}
+
+func newTengoDB(cfg DBConfig) (tengoDB *TengoDB, err error) {
 	tengoDB = &TengoDB{
 		ImmutableMap: tengo.ImmutableMap{
 			Value: make(map[string]tengo.Object),
 		},
 	}
-	cfg := &DBConfig{}
-	err = json.Unmarshal([]byte(config), cfg)
-	if err != nil {
-		return nil, err
-	}
 	var db *sql.DB
 
-	db, err = sql.Open(DriverName, cfg.DSN)
+	tengoDB.driverName = cfg.DriverName()
+	db, err = sql.Open(tengoDB.driverName, cfg.DSN)
 	if err != nil {
-		return // 此处返回闭包,带出error
+		err = errors.WithMessagef(err, "sql.Open:%s", cfg.DSN)
+		return nil, err
 	}
 	tengoDB.sqlDB = db
-
+	err = cfg.ApplyPool(db)
 	if err != nil {
-		err = errors.WithMessagef(err, "sql.Open:%s", cfg.DSN)
+		db.Close()
 		return nil, err
 	}
+	if cfg.PingOnOpen {
+		ctx, cancel := context.WithTimeout(context.Background(), PingOnOpenTimeout)
+		err = db.PingContext(ctx)
+		cancel()
+		if err != nil {
+			db.Close()
+			err = errors.WithMessagef(err, "ping:%s", cfg.DSN)
+			return nil, err
+		}
+	}
 	if tengoDB.sqlDB == nil {
 		err = errors.New("tengoDB.sqlDB is nil")
 		panic(err)
@@ -69,6 +118,9 @@ func NewTengoDB(config string) (tengoDB *TengoDB, err error) {
 	//注入tengo 脚本方法
 	methods := map[string]tengo.CallableFunc{
 		"execOrQueryContext": tengoDB.TengoExecOrQueryContext,
+		"execOrQueryNamed":   tengoDB.TengoExecOrQueryNamed,
+		"query":              tengoDB.TengoQuery,
+		"exec":               tengoDB.TengoExec,
 		"beginTx":            tengoDB.BeginTx,
 	}
 
@@ -79,7 +131,6 @@ func NewTengoDB(config string) (tengoDB *TengoDB, err error) {
 		}
 
 	}
-	tengoDBMap[config] = tengoDB
 	return tengoDB, nil
 }
 
@@ -121,8 +172,55 @@ func (db *TengoDB) ExecOrQueryContext(ctx context.Context, sql string) (out stri
 	return out, err
 }
 
+// TengoExecOrQueryNamed 接收模板输出,以 ? 占位符加绑定参数的方式执行,多条语句见 ExecOrQueryNamedContext
+func (db *TengoDB) TengoExecOrQueryNamed(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, tplOut, err := parseCtxTplOutArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	out, err := ExecOrQueryNamedContext(ctx, db.sqlDB, db.driverName, tplOut.Out, tplOut.Data)
+	if err != nil {
+		return nil, err
+	}
+	ret = &tengo.String{Value: out}
+	return ret, err
+}
+
+// ExecOrQueryArgsContext 执行 ? 占位符语句,参数由驱动绑定
+func (db *TengoDB) ExecOrQueryArgsContext(ctx context.Context, sql string, args ...any) (out string, err error) {
+	out, err = ExecOrQueryArgsContext(ctx, db.sqlDB, sql, args...)
+	return out, err
+}
+
+// TengoQuery 查询并返回 tengo 数组,每行为 ImmutableMap,列值保留原生类型
+func (db *TengoDB) TengoQuery(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, sql, err := parseCtxSQLArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	records, err := QueryContext(ctx, db.sqlDB, sql)
+	if err != nil {
+		return nil, err
+	}
+	return RecordsToTengo(records)
+}
+
+// TengoExec 执行非查询语句,返回 {lastInsertId,rowsAffected,results},多语句时 results 记录每条语句的结果
+func (db *TengoDB) TengoExec(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, sql, err := parseCtxSQLArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	results, err := ExecContextByDriver(ctx, db.sqlDB, db.driverName, sql)
+	if err != nil {
+		return nil, err
+	}
+	return ExecResultsToTengo(results), nil
+}
+
+// BeginTx 开启事务,可选第二个参数设置事务选项,如 {isolation:"serializable",readOnly:true}
 func (tengoDB *TengoDB) BeginTx(args ...tengo.Object) (ret tengo.Object, err error) {
-	if len(args) != 1 {
+	if len(args) != 1 && len(args) != 2 {
 		return nil, tengo.ErrWrongNumArguments
 	}
 	ctxObjPossible := args[0]
@@ -135,13 +233,25 @@ func (tengoDB *TengoDB) BeginTx(args ...tengo.Object) (ret tengo.Object, err err
 		}
 	}
 	ctx := ctxObj.Context
-	tx, err := newTengoTx(ctx, tengoDB.sqlDB)
+	var opts *sql.TxOptions
+	if len(args) == 2 {
+		opts, err = ParseTxOptions(args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	tx, err := newTengoTx(ctx, tengoDB.sqlDB, tengoDB.driverName, opts)
 	return tx, err
 }
 
 type TengoTx struct {
 	tengo.ImmutableMap
-	sqlTx *sql.Tx
+	ctx          context.Context
+	tracker      *TxTracker // 顶级事务所在 ctx 携带的 TxTracker
+	sqlTx        *sql.Tx
+	driverName   string
+	savepoint    string // 不为空时为嵌套事务
+	savepointSeq int
 }
 
 func (t *TengoTx) TypeName() string {
@@ -151,11 +261,63 @@ func (t *TengoTx) String() string {
 	return ""
 }
 
+// DriverName 事务所属数据库的驱动名称
+func (t *TengoTx) DriverName() string {
+	return t.driverName
+}
+
+// Commit 提交事务,嵌套事务则释放保存点
 func (t *TengoTx) Commit(args ...tengo.Object) (ret tengo.Object, err error) {
+	if t.savepoint != "" {
+		_, err = t.sqlTx.ExecContext(t.ctx, fmt.Sprintf("RELEASE SAVEPOINT %s", t.savepoint))
+		return nil, err
+	}
 	err = t.sqlTx.Commit()
+	t.tracker.remove(t)
 	return nil, err
 }
 
+// BeginTx 在事务内开启嵌套事务(基于保存点),返回对象与 TengoTx 方法一致
+func (t *TengoTx) BeginTx(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	ctxObjPossible := args[0]
+	ctxObj, ok := ctxObjPossible.(*tengocontext.TengoContext)
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "context",
+			Expected: "context.Context",
+			Found:    ctxObjPossible.TypeName(),
+		}
+	}
+	ctx := ctxObj.Context
+	t.savepointSeq++
+	savepoint := fmt.Sprintf("%s_%d", t.savepointPrefix(), t.savepointSeq)
+	_, err = t.sqlTx.ExecContext(ctx, fmt.Sprintf("SAVEPOINT %s", savepoint))
+	if err != nil {
+		return nil, err
+	}
+	nested := &TengoTx{
+		ImmutableMap: tengo.ImmutableMap{
+			Value: make(map[string]tengo.Object),
+		},
+		ctx:        ctx,
+		sqlTx:      t.sqlTx,
+		driverName: t.driverName,
+		savepoint:  savepoint,
+	}
+	nested.injectMethods()
+	return nested, nil
+}
+
+func (t *TengoTx) savepointPrefix() string {
+	if t.savepoint == "" {
+		return "sp"
+	}
+	return t.savepoint
+}
+
 func (t *TengoTx) ExecOrQueryContext(args ...tengo.Object) (ret tengo.Object, err error) {
 	if len(args) != 2 {
 		return nil, tengo.ErrWrongNumArguments
@@ -187,13 +349,61 @@ func (t *TengoTx) ExecOrQueryContext(args ...tengo.Object) (ret tengo.Object, er
 	ret = &tengo.String{Value: out}
 	return ret, err
 }
+
+// ExecOrQueryNamed 事务内以 ? 占位符加绑定参数的方式执行模板输出,多条语句见 ExecOrQueryNamedContext
+func (t *TengoTx) ExecOrQueryNamed(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, tplOut, err := parseCtxTplOutArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	out, err := ExecOrQueryNamedContext(ctx, t.sqlTx, t.driverName, tplOut.Out, tplOut.Data)
+	if err != nil {
+		return nil, err
+	}
+	ret = &tengo.String{Value: out}
+	return ret, err
+}
+
+// Query 事务内查询,返回值同 TengoDB.TengoQuery
+func (t *TengoTx) Query(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, sql, err := parseCtxSQLArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	records, err := QueryContext(ctx, t.sqlTx, sql)
+	if err != nil {
+		return nil, err
+	}
+	return RecordsToTengo(records)
+}
+
+// Exec 事务内执行非查询语句,返回值同 TengoDB.TengoExec
+func (t *TengoTx) Exec(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, sql, err := parseCtxSQLArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	results, err := ExecContextByDriver(ctx, t.sqlTx, t.driverName, sql)
+	if err != nil {
+		return nil, err
+	}
+	return ExecResultsToTengo(results), nil
+}
+
+// Rollback 回滚事务,嵌套事务则回滚到保存点
 func (t *TengoTx) Rollback(args ...tengo.Object) (ret tengo.Object, err error) {
+	if t.savepoint != "" {
+		_, err = t.sqlTx.ExecContext(t.ctx, fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", t.savepoint))
+		return nil, err
+	}
 	err = t.sqlTx.Rollback()
+	t.tracker.remove(t)
 	return nil, err
 }
 
-func newTengoTx(ctx context.Context, db *sql.DB) (t *TengoTx, err error) {
-	tx, err := db.BeginTx(ctx, nil)
+// newTengoTx ctx 携带 TxTracker 时记录事务,提交或回滚后移除
+func newTengoTx(ctx context.Context, db *sql.DB, driverName string, opts *sql.TxOptions) (t *TengoTx, err error) {
+	tx, err := db.BeginTx(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -201,13 +411,26 @@ func newTengoTx(ctx context.Context, db *sql.DB) (t *TengoTx, err error) {
 		ImmutableMap: tengo.ImmutableMap{
 			Value: make(map[string]tengo.Object),
 		},
-		sqlTx: tx,
+		ctx:        ctx,
+		tracker:    txTrackerFrom(ctx),
+		sqlTx:      tx,
+		driverName: driverName,
 	}
+	t.injectMethods()
+	t.tracker.add(t)
+	return t, err
+}
 
+func (t *TengoTx) injectMethods() {
 	methods := map[string]tengo.CallableFunc{
+		"beginTx":            t.BeginTx,
 		"commit":             t.Commit,
 		"execOrQueryContext": t.ExecOrQueryContext,
+		"execOrQueryNamed":   t.ExecOrQueryNamed,
+		"query":              t.Query,
+		"exec":               t.Exec,
 		"rollback":           t.Rollback,
+		"finish":             TengoFinishTx(t),
 	}
 	for name, fn := range methods {
 		t.Value[name] = &tengo.UserFunction{
@@ -215,5 +438,155 @@ func newTengoTx(ctx context.Context, db *sql.DB) (t *TengoTx, err error) {
 			Value: fn,
 		}
 	}
-	return t, err
+}
+
+// parseCtxSQLArgs 解析 (ctx,sql) 形式的脚本参数
+func parseCtxSQLArgs(args ...tengo.Object) (ctx context.Context, sql string, err error) {
+	if len(args) != 2 {
+		return nil, "", tengo.ErrWrongNumArguments
+	}
+	ctxObjPossible := args[0]
+	ctxObj, ok := ctxObjPossible.(*tengocontext.TengoContext)
+	if !ok {
+		return nil, "", tengo.ErrInvalidArgumentType{
+			Name:     "context",
+			Expected: "context.Context",
+			Found:    ctxObjPossible.TypeName(),
+		}
+	}
+	sqlObj := args[1]
+	sql, ok = tengo.ToString(sqlObj)
+	if !ok {
+		return nil, "", tengo.ErrInvalidArgumentType{
+			Name:     "sql",
+			Expected: "string",
+			Found:    sqlObj.TypeName(),
+		}
+	}
+	return ctxObj.Context, sql, nil
+}
+
+// parseCtxTplOutArgs 解析 (ctx,templateOut) 形式的脚本参数
+func parseCtxTplOutArgs(args ...tengo.Object) (ctx context.Context, tplOut *tengotemplate.TemplateOut, err error) {
+	if len(args) != 2 {
+		return nil, nil, tengo.ErrWrongNumArguments
+	}
+	ctxObjPossible := args[0]
+	ctxObj, ok := ctxObjPossible.(*tengocontext.TengoContext)
+	if !ok {
+		return nil, nil, tengo.ErrInvalidArgumentType{
+			Name:     "context",
+			Expected: "context.Context",
+			Found:    ctxObjPossible.TypeName(),
+		}
+	}
+	tplOutObj := args[1]
+	tplOut, ok = tplOutObj.(*tengotemplate.TemplateOut)
+	if !ok {
+		return nil, nil, tengo.ErrInvalidArgumentType{
+			Name:     "templateOut",
+			Expected: "template-out",
+			Found:    tplOutObj.TypeName(),
+		}
+	}
+	return ctxObj.Context, tplOut, nil
+}
+
+// RecordsToTengo 将查询记录转换为 tengo 数组,NULL 转为 tengo.UndefinedValue
+func RecordsToTengo(records []map[string]any) (arr *tengo.Array, err error) {
+	arr = &tengo.Array{Value: make([]tengo.Object, 0, len(records))}
+	for _, record := range records {
+		row := &tengo.ImmutableMap{Value: make(map[string]tengo.Object, len(record))}
+		for k, v := range record {
+			obj, err := tengo.FromInterface(v)
+			if err != nil {
+				return nil, err
+			}
+			row.Value[k] = obj
+		}
+		arr.Value = append(arr.Value, row)
+	}
+	return arr, nil
+}
+
+// ExecResultsToTengo 汇总执行结果,lastInsertId 取最后一条语句,rowsAffected 为各语句之和
+func ExecResultsToTengo(results []ExecResult) (ret *tengo.ImmutableMap) {
+	var lastInsertId, rowsAffected int64
+	resultsObj := &tengo.ImmutableArray{Value: make([]tengo.Object, 0, len(results))}
+	for _, result := range results {
+		lastInsertId = result.LastInsertId
+		rowsAffected += result.RowsAffected
+		resultsObj.Value = append(resultsObj.Value, &tengo.ImmutableMap{
+			Value: map[string]tengo.Object{
+				"lastInsertId": &tengo.Int{Value: result.LastInsertId},
+				"rowsAffected": &tengo.Int{Value: result.RowsAffected},
+			},
+		})
+	}
+	ret = &tengo.ImmutableMap{
+		Value: map[string]tengo.Object{
+			"lastInsertId": &tengo.Int{Value: lastInsertId},
+			"rowsAffected": &tengo.Int{Value: rowsAffected},
+			"results":      resultsObj,
+		},
+	}
+	return ret
+}
+
+// ParseTxOptions 将脚本中的 {isolation,readOnly} 转换为 sql.TxOptions,isolation 忽略大小写、空格、下划线及中划线,如 "read committed","READ_COMMITTED","readCommitted"
+func ParseTxOptions(obj tengo.Object) (opts *sql.TxOptions, err error) {
+	var m map[string]tengo.Object
+	switch o := obj.(type) {
+	case *tengo.Map:
+		m = o.Value
+	case *tengo.ImmutableMap:
+		m = o.Value
+	case *tengo.Undefined:
+		return nil, nil
+	default:
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "options",
+			Expected: "map",
+			Found:    obj.TypeName(),
+		}
+	}
+	opts = &sql.TxOptions{}
+	if isolationObj, ok := m["isolation"]; ok {
+		isolation, ok := tengo.ToString(isolationObj)
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{
+				Name:     "isolation",
+				Expected: "string",
+				Found:    isolationObj.TypeName(),
+			}
+		}
+		opts.Isolation, err = ParseIsolationLevel(isolation)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if readOnlyObj, ok := m["readOnly"]; ok {
+		opts.ReadOnly = !readOnlyObj.IsFalsy()
+	}
+	return opts, nil
+}
+
+// ParseIsolationLevel 解析事务隔离级别名称
+func ParseIsolationLevel(isolation string) (level sql.IsolationLevel, err error) {
+	name := normalizeIsolationName(isolation)
+	for level = sql.LevelDefault; level <= sql.LevelLinearizable; level++ {
+		if normalizeIsolationName(level.String()) == name {
+			return level, nil
+		}
+	}
+	err = errors.Errorf("unsupported isolation level:%s", isolation)
+	return sql.LevelDefault, err
+}
+
+func normalizeIsolationName(name string) string {
+	name = strings.ToLower(name)
+	for _, sep := range []string{" ", "_", "-"} {
+		name = strings.ReplaceAll(name, sep, "")
+	}
+	return name
 }
@@ -0,0 +1,142 @@
+package tengodb
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TengoDBRegistry 并发安全的 TengoDB 缓存,以解析后的 DBConfig 为键,语义相同的配置共享连接池;
+// 每次 Get 引用计数加一,TengoDB.Close 减一,最后一个引用释放时才关闭连接池
+type TengoDBRegistry struct {
+	tengoDBMap map[string]*TengoDB
+	refs       map[*TengoDB]int
+	lock       sync.Mutex
+}
+
+func NewTengoDBRegistry() (r *TengoDBRegistry) {
+	r = &TengoDBRegistry{
+		tengoDBMap: make(map[string]*TengoDB),
+		refs:       make(map[*TengoDB]int),
+	}
+	return r
+}
+
+// Get 获取配置对应的实例并增加引用计数,不存在时创建;使用完毕后调用 TengoDB.Close 释放。
+// 创建(含 pingOnOpen)在锁外进行,不阻塞其它配置;并发创建同一配置时保留先写入的实例,关闭多余的连接池
+func (r *TengoDBRegistry) Get(config string) (tengoDB *TengoDB, err error) {
+	cfg, key, err := normalizeDBConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	tengoDB = r.acquire(key)
+	if tengoDB != nil {
+		return tengoDB, nil
+	}
+	created, err := newTengoDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	tengoDB, ok := r.tengoDBMap[key]
+	if ok {
+		r.refs[tengoDB]++
+		r.lock.Unlock()
+		created.sqlDB.Close()
+		return tengoDB, nil
+	}
+	created.registry, created.registryKey = r, key
+	r.tengoDBMap[key] = created
+	r.refs[created] = 1
+	r.lock.Unlock()
+	return created, nil
+}
+
+// acquire 实例存在时增加引用计数并返回,否则返回 nil
+func (r *TengoDBRegistry) acquire(key string) (tengoDB *TengoDB) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	tengoDB, ok := r.tengoDBMap[key]
+	if !ok {
+		return nil
+	}
+	r.refs[tengoDB]++
+	return tengoDB
+}
+
+// Close 忽略引用计数,强制关闭并移除配置对应的实例,实例不存在时忽略
+func (r *TengoDBRegistry) Close(config string) (err error) {
+	_, key, err := normalizeDBConfig(config)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	tengoDB, ok := r.tengoDBMap[key]
+	if ok {
+		delete(r.tengoDBMap, key)
+		delete(r.refs, tengoDB)
+	}
+	r.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	return tengoDB.sqlDB.Close()
+}
+
+// release 引用计数减一,返回是否需要关闭连接池(最后一个引用,或实例已被强制移出缓存)
+func (r *TengoDBRegistry) release(key string, tengoDB *TengoDB) (last bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.tengoDBMap[key] != tengoDB {
+		return true
+	}
+	r.refs[tengoDB]--
+	if r.refs[tengoDB] > 0 {
+		return false
+	}
+	delete(r.tengoDBMap, key)
+	delete(r.refs, tengoDB)
+	return true
+}
+
+// CloseAll 忽略引用计数,强制关闭并移除所有实例,返回第一个关闭错误
+func (r *TengoDBRegistry) CloseAll() (err error) {
+	r.lock.Lock()
+	tengoDBMap := r.tengoDBMap
+	r.tengoDBMap = make(map[string]*TengoDB)
+	r.refs = make(map[*TengoDB]int)
+	r.lock.Unlock()
+	for _, tengoDB := range tengoDBMap {
+		if closeErr := tengoDB.sqlDB.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// normalizeDBConfig 解析配置并生成规范化的键(补全默认驱动,统一时间格式,忽略字段顺序及空白差异)
+func normalizeDBConfig(config string) (cfg DBConfig, key string, err error) {
+	err = json.Unmarshal([]byte(config), &cfg)
+	if err != nil {
+		err = errors.WithMessagef(err, "db config:%s", config)
+		return cfg, "", err
+	}
+	cfg.Driver = cfg.DriverName()
+	for _, d := range []*string{&cfg.ConnMaxLifetime, &cfg.ConnMaxIdleTime} {
+		if *d == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(*d)
+		if err != nil {
+			return cfg, "", err
+		}
+		*d = duration.String()
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return cfg, "", err
+	}
+	return cfg, string(b), nil
+}
@@ -1 +1,334 @@
 package tengodb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d5/tengo/v2"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/tengolib/tengocontext"
+	"github.com/suifengpiao14/tengolib/tengotemplate"
+)
+
+func TestSplitStatements(t *testing.T) {
+	sqls := "insert into t (a) values (?); update t set b='x;y' where a=?;; delete from t where c=\"\\\";\""
+	statements := SplitStatements(sqls)
+	require.Equal(t, []string{
+		"insert into t (a) values (?)",
+		"update t set b='x;y' where a=?",
+		"delete from t where c=\"\\\";\"",
+	}, statements)
+	require.Equal(t, 1, countPlaceholders(statements[0]))
+	require.Equal(t, 0, countPlaceholders("select '?' from t"))
+}
+
+func TestSplitStatementsCommentsAndDollarQuotes(t *testing.T) {
+	sqls := `insert into t (a) values (?); -- a;b?
+/* c;d? */ update t set b=? where a=?;
+create function f() returns int as $$ begin return 1; end $$ language plpgsql;
+create function g() returns text as $body$ select 'x;$$' $body$ language sql`
+	statements := SplitStatements(sqls)
+	require.Equal(t, []string{
+		"insert into t (a) values (?)",
+		"-- a;b?\n/* c;d? */ update t set b=? where a=?",
+		"create function f() returns int as $$ begin return 1; end $$ language plpgsql",
+		"create function g() returns text as $body$ select 'x;$$' $body$ language sql",
+	}, statements)
+	require.Equal(t, 2, countPlaceholders(statements[1]))
+	require.Equal(t, 0, countPlaceholders("select $$?$$, $a$?$a$ -- ?"))
+	require.Equal(t, "update t set a=$1 /* $3 */ where b=$$ $4 $$ and c=$2",
+		replaceDollarPlaceholders("update t set a=$3 /* $3 */ where b=$$ $4 $$ and c=$4", func(n int) int { return n - 2 }))
+	require.Equal(t, "select a from t where b=? and c=?", NormalizeSQL("select a -- comment; ?\nfrom t where b=? -- '\nand c=?"))
+}
+
+func TestDBConfigDriverName(t *testing.T) {
+	require.Equal(t, "mysql", DBConfig{DSN: "root@/test"}.DriverName())
+	require.Equal(t, "postgres", DBConfig{DSN: "postgres://localhost/test", Driver: "postgres"}.DriverName())
+}
+
+func TestNewTengoDBPingOnOpen(t *testing.T) {
+	_, err := NewTengoDB(`{"dsn":"root:123456@tcp(127.0.0.1:1)/test?timeout=100ms","pingOnOpen":true}`)
+	require.Error(t, err)
+	_, err = NewTengoDB(`{"dsn":"root:123456@tcp(127.0.0.1:1)/test","connMaxLifetime":"1x"}`)
+	require.Error(t, err)
+}
+
+func TestTengoDBRegistryGetOutsideLock(t *testing.T) {
+	// 接受连接但不响应握手,pingOnOpen 阻塞至超时
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	timeout := PingOnOpenTimeout
+	PingOnOpenTimeout = 2 * time.Second
+	defer func() { PingOnOpenTimeout = timeout }()
+
+	r := NewTengoDBRegistry()
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := r.Get(`{"dsn":"root:123456@tcp(` + listener.Addr().String() + `)/test","pingOnOpen":true}`)
+		blocked <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	begin := time.Now()
+	tengoDB, err := r.Get(`{"driver":"sqlite3","dsn":":memory:","pingOnOpen":true}`)
+	require.NoError(t, err)
+	require.Less(t, time.Since(begin), time.Second)
+	require.NoError(t, tengoDB.Close())
+	select {
+	case err = <-blocked:
+		require.Error(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("pingOnOpen not bounded by PingOnOpenTimeout")
+	}
+}
+
+func TestTengoDBRegistry(t *testing.T) {
+	r := NewTengoDBRegistry()
+	db1, err := r.Get(`{"dsn":"root:123456@tcp(127.0.0.1:3306)/test","connMaxLifetime":"60m"}`)
+	require.NoError(t, err)
+	db2, err := r.Get(`{ "connMaxLifetime":"1h", "driver":"mysql", "dsn":"root:123456@tcp(127.0.0.1:3306)/test"}`)
+	require.NoError(t, err)
+	require.Same(t, db1, db2)
+
+	err = r.Close(`{"dsn":"root:123456@tcp(127.0.0.1:3306)/test","connMaxLifetime":"1h"}`)
+	require.NoError(t, err)
+	db3, err := r.Get(`{"dsn":"root:123456@tcp(127.0.0.1:3306)/test","connMaxLifetime":"1h"}`)
+	require.NoError(t, err)
+	require.NotSame(t, db1, db3)
+	require.NoError(t, r.CloseAll())
+}
+
+func TestTengoDBCloseRemovesFromRegistry(t *testing.T) {
+	r := NewTengoDBRegistry()
+	config := `{"dsn":"root:123456@tcp(127.0.0.1:3306)/test"}`
+	db1, err := r.Get(config)
+	require.NoError(t, err)
+	require.NoError(t, db1.Close())
+	db2, err := r.Get(config)
+	require.NoError(t, err)
+	require.NotSame(t, db1, db2)
+	require.NoError(t, r.CloseAll())
+}
+
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1) // :memory: 每个连接独立,固定单连接
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestQueryContextColumnTypes(t *testing.T) {
+	db := openSQLite(t)
+	ctx := context.Background()
+	_, err := db.ExecContext(ctx, `create table t (id INTEGER, price REAL, ok BOOLEAN, created_at DATETIME, deleted_at DATETIME, data BLOB, name TEXT)`)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `insert into t values (1, 1.5, 1, '2023-01-02 03:04:05', NULL, x'0102', 'tom')`)
+	require.NoError(t, err)
+	records, err := QueryContext(ctx, db, "select * from t where id=?", 1)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	record := records[0]
+	require.Equal(t, int64(1), record["id"])
+	require.Equal(t, 1.5, record["price"])
+	require.Equal(t, true, record["ok"])
+	createdAt, ok := record["created_at"].(time.Time)
+	require.True(t, ok)
+	require.Equal(t, "2023-01-02 03:04:05", createdAt.Format("2006-01-02 15:04:05"))
+	require.Nil(t, record["deleted_at"])
+	require.Equal(t, []byte{1, 2}, record["data"])
+	require.Equal(t, "tom", record["name"])
+
+	arr, err := RecordsToTengo(records)
+	require.NoError(t, err)
+	row := arr.Value[0].(*tengo.ImmutableMap)
+	require.IsType(t, &tengo.Time{}, row.Value["created_at"])
+	require.Equal(t, tengo.UndefinedValue, row.Value["deleted_at"])
+}
+
+func TestParseColumnFallback(t *testing.T) {
+	v, err := parseIntColumn("18446744073709551615")
+	require.NoError(t, err)
+	require.Equal(t, "18446744073709551615", v)
+	_, err = parseIntColumn("abc")
+	require.Error(t, err)
+	require.Equal(t, "0000-00-00 00:00:00", parseTimeColumn("0000-00-00 00:00:00"))
+}
+
+// recordStatement 驱动实际收到的语句及绑定参数
+type recordStatement struct {
+	Query string
+	Args  []driver.Value
+}
+
+var (
+	recordStatements     []recordStatement
+	recordStatementsLock sync.Mutex
+	registerRecordDriver sync.Once
+)
+
+// recordDriver 包装 sqlite3 驱动,记录收到的语句及参数
+type recordDriver struct {
+	sqlite3.SQLiteDriver
+}
+
+func (d *recordDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &recordConn{SQLiteConn: conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+type recordConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c *recordConn) record(query string, args []driver.NamedValue) {
+	values := make([]driver.Value, 0, len(args))
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+	recordStatementsLock.Lock()
+	defer recordStatementsLock.Unlock()
+	recordStatements = append(recordStatements, recordStatement{Query: query, Args: values})
+}
+
+func (c *recordConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.record(query, args)
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+func (c *recordConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.record(query, args)
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+func newRecordTengoDB(t *testing.T) *TengoDB {
+	registerRecordDriver.Do(func() {
+		sql.Register("sqlite3_record", &recordDriver{})
+		tengotemplate.RegisterDialect("sqlite3_record", tengotemplate.GetDialect(tengotemplate.DRIVER_SQLITE))
+	})
+	tengoDB, err := newTengoDB(DBConfig{DSN: ":memory:", Driver: "sqlite3_record", MaxOpenConns: 1})
+	require.NoError(t, err)
+	t.Cleanup(func() { tengoDB.Close() })
+	_, err = tengoDB.GetDB().Exec("create table user (id INTEGER PRIMARY KEY, name TEXT)")
+	require.NoError(t, err)
+	return tengoDB
+}
+
+func TestTengoExecOrQueryNamed(t *testing.T) {
+	tengoDB := newRecordTengoDB(t)
+	tpl := tengotemplate.NewTemplate()
+	tpl.AddTpl("addUser", "insert into user (name) values (:name)")
+	script := tengo.NewScript([]byte(`
+	db.execOrQueryNamed(ctx, tpl.exec("addUser", {name: "O'Brien"}))
+	tx := db.beginTx(ctx)
+	tx.execOrQueryNamed(ctx, tpl.exec("addUser", {name: "D'Arcy"}))
+	tx.commit()
+	`))
+	require.NoError(t, script.Add("db", tengoDB))
+	require.NoError(t, script.Add("tpl", tpl))
+	require.NoError(t, script.Add("ctx", tengocontext.NewTengoContext(context.Background())))
+	recordStatementsLock.Lock()
+	recordStatements = nil
+	recordStatementsLock.Unlock()
+	_, err := script.Run()
+	require.NoError(t, err)
+
+	recordStatementsLock.Lock()
+	statements := recordStatements
+	recordStatementsLock.Unlock()
+	require.Equal(t, []recordStatement{
+		{Query: "insert into user (name) values (?)", Args: []driver.Value{"O'Brien"}},
+		{Query: "insert into user (name) values (?)", Args: []driver.Value{"D'Arcy"}},
+	}, statements)
+
+	records, err := QueryContext(context.Background(), tengoDB.GetDB(), "select name from user order by id")
+	require.NoError(t, err)
+	require.Equal(t, []map[string]any{{"name": "O'Brien"}, {"name": "D'Arcy"}}, records)
+}
+
+func TestTengoExecOrQueryNamedMultiStatement(t *testing.T) {
+	tengoDB := newRecordTengoDB(t)
+	tpl := tengotemplate.NewTemplate()
+	tpl.AddTpl("renameUser", "insert into user (name) values (:name); update user set name=:newName where name=:name; select name from user")
+	script := tengo.NewScript([]byte(`
+	out := db.execOrQueryNamed(ctx, tpl.exec("renameUser", {name: "a;b", newName: "c"}))
+	`))
+	require.NoError(t, script.Add("db", tengoDB))
+	require.NoError(t, script.Add("tpl", tpl))
+	require.NoError(t, script.Add("ctx", tengocontext.NewTengoContext(context.Background())))
+	recordStatementsLock.Lock()
+	recordStatements = nil
+	recordStatementsLock.Unlock()
+	c, err := script.Run()
+	require.NoError(t, err)
+	require.Equal(t, "c", c.Get("out").String())
+
+	recordStatementsLock.Lock()
+	statements := recordStatements
+	recordStatementsLock.Unlock()
+	require.Equal(t, []recordStatement{
+		{Query: "insert into user (name) values (?)", Args: []driver.Value{"a;b"}},
+		{Query: "update user set name=? where name=?", Args: []driver.Value{"c", "a;b"}},
+		{Query: "select name from user", Args: []driver.Value{}},
+	}, statements)
+}
+
+// execRecorder 记录 ExecContext 收到的语句及参数
+type execRecorder struct {
+	statements []string
+	args       [][]any
+}
+
+func (r *execRecorder) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	r.statements = append(r.statements, query)
+	r.args = append(r.args, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (r *execRecorder) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return nil, sql.ErrNoRows
+}
+
+func TestExecContextByDriverPostgres(t *testing.T) {
+	statement, args, err := tengotemplate.ToNamedSQLByDriver(tengotemplate.DRIVER_POSTGRES,
+		"insert into t (a,b) values (:a,:b); update t set b=:b, c='$9' where a=:c", map[string]interface{}{"a": 1, "b": 2, "c": 3})
+	require.NoError(t, err)
+	require.Equal(t, "insert into t (a,b) values ($1,$2); update t set b=$3, c='$9' where a=$4", statement)
+	r := &execRecorder{}
+	results, err := ExecContextByDriver(context.Background(), r, tengotemplate.DRIVER_POSTGRES, statement, args...)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, []string{
+		"insert into t (a,b) values ($1,$2)",
+		"update t set b=$1, c='$9' where a=$2",
+	}, r.statements)
+	require.Equal(t, [][]any{{1, 2}, {2, 3}}, r.args)
+
+	_, err = ExecContextByDriver(context.Background(), &execRecorder{}, tengotemplate.DRIVER_POSTGRES, "update t set a=$1; update t set b=$3", 1, 2)
+	require.Error(t, err)
+	_, err = ExecContextByDriver(context.Background(), &execRecorder{}, tengotemplate.DRIVER_POSTGRES, "update t set a=$1", 1, 2)
+	require.Error(t, err)
+
+	r = &execRecorder{}
+	_, err = ExecContextByDriver(context.Background(), r, tengotemplate.DRIVER_MYSQL, "update t set a=?; update t set b=?", 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, [][]any{{1}, {2}}, r.args)
+}
@@ -2,6 +2,11 @@ package tengodb
 
 import (
 	"context"
+	stdsql "database/sql"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/d5/tengo/v2"
 	"github.com/pkg/errors"
@@ -11,6 +16,19 @@ import (
 type TengoMemoryDB struct {
 	tengo.ImmutableMap
 	InOutMap map[string]string
+	rules    []*MemoryDBRule
+	txs      []*TengoMemoryTx
+	lock     sync.Mutex
+}
+
+// Close 内存实现无需释放资源
+func (m *TengoMemoryDB) Close() (err error) {
+	return nil
+}
+
+// Health 内存实现始终可用
+func (m *TengoMemoryDB) Health(ctx context.Context) (err error) {
+	return nil
 }
 
 func (m *TengoMemoryDB) TypeName() string {
@@ -20,12 +38,205 @@ func (m *TengoMemoryDB) String() string {
 	return ""
 }
 func (m *TengoMemoryDB) ExecOrQueryContext(ctx context.Context, sql string) (out string, err error) {
-	out, ok := m.InOutMap[sql]
-	if !ok {
-		err = errors.Errorf("not found by sql:%s", sql)
-		return "", err
+	return m.lookup(RECORD_METHOD_EXEC_OR_QUERY, sql)
+}
+
+// lookup 依次按原样(仅 execOrQueryContext)、录制键 RecordKey(method,sql) 查找 InOutMap,未命中时以不带方法前缀的 sql 匹配规则
+func (m *TengoMemoryDB) lookup(method string, sql string) (out string, err error) {
+	if method == RECORD_METHOD_EXEC_OR_QUERY {
+		out, ok := m.InOutMap[sql]
+		if ok {
+			return out, nil
+		}
+	}
+	out, ok := m.InOutMap[RecordKey(method, sql)] // 录制文件中保存的是格式化后的sql
+	if ok {
+		return out, nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, rule := range m.rules {
+		out, ok = rule.call(method, sql)
+		if ok {
+			return out, nil
+		}
+	}
+	err = errors.Errorf("not found by %s sql:%s", method, sql)
+	return "", err
+}
+
+// QueryContext 回放 query 的录制结果或 query 规则的输出(json 格式的记录数组),时间列回放为字符串
+func (m *TengoMemoryDB) QueryContext(ctx context.Context, sql string) (records []map[string]any, err error) {
+	out, err := m.lookup(RECORD_METHOD_QUERY, sql)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRecords(out)
+}
+
+// ExecContext 回放 exec 的录制结果或 exec 规则的输出(json 格式的 ExecResult 数组)
+func (m *TengoMemoryDB) ExecContext(ctx context.Context, sql string) (results []ExecResult, err error) {
+	out, err := m.lookup(RECORD_METHOD_EXEC, sql)
+	if err != nil {
+		return nil, err
+	}
+	results = make([]ExecResult, 0)
+	err = json.Unmarshal([]byte(out), &results)
+	if err != nil {
+		err = errors.WithMessagef(err, "exec output:%s", out)
+		return nil, err
 	}
-	return out, nil
+	return results, nil
+}
+
+// decodeRecords 解析 query 录制的 json,整数还原为 int64
+func decodeRecords(out string) (records []map[string]any, err error) {
+	decoder := json.NewDecoder(strings.NewReader(out))
+	decoder.UseNumber()
+	records = make([]map[string]any, 0)
+	err = decoder.Decode(&records)
+	if err != nil {
+		err = errors.WithMessagef(err, "query output:%s", out)
+		return nil, err
+	}
+	for _, record := range records {
+		for k, v := range record {
+			number, ok := v.(json.Number)
+			if !ok {
+				continue
+			}
+			if i, err := number.Int64(); err == nil {
+				record[k] = i
+				continue
+			}
+			record[k], err = number.Float64()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return records, nil
+}
+
+// AddRule 添加匹配规则,InOutMap 未命中时按添加顺序匹配
+func (m *TengoMemoryDB) AddRule(rules ...*MemoryDBRule) (err error) {
+	for _, rule := range rules {
+		err = rule.compile()
+		if err != nil {
+			return err
+		}
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, rule := range rules {
+		rule.lock = &m.lock
+	}
+	m.rules = append(m.rules, rules...)
+	return nil
+}
+
+const (
+	MATCH_TYPE_EXACT  = "exact"  // 完全一致
+	MATCH_TYPE_SPACE  = "space"  // 忽略空白差异
+	MATCH_TYPE_REGEXP = "regexp" // 正则匹配格式化后的sql
+	MATCH_TYPE_GLOB   = "glob"   // 通配符匹配格式化后的sql,* 匹配任意字符串,? 匹配单个字符
+	MATCH_TYPE_PARAM  = "param"  // 忽略字面量(字符串、数字)及 in 列表长度差异
+)
+
+// MemoryDBRule 内存数据库匹配规则
+type MemoryDBRule struct {
+	Match   string   `json:"match"`   // 匹配方式,默认 space
+	Method  string   `json:"method"`  // 限定调用的方法(RECORD_METHOD_*),为空时匹配所有方法;query、exec 规则的输出与录制格式相同
+	SQL     string   `json:"sql"`     // sql 或匹配模式,不含方法前缀
+	Outputs []string `json:"outputs"` // 按调用顺序依次返回,超出后重复返回最后一个
+	Times   int      `json:"times"`   // 最多匹配次数,0 表示不限制
+	calls   int
+	regexp  *regexp.Regexp
+	lock    *sync.Mutex // 所属 TengoMemoryDB 的锁,AddRule 时设置
+}
+
+// Calls 规则已命中的次数
+func (r *MemoryDBRule) Calls() int {
+	if r.lock != nil {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+	}
+	return r.calls
+}
+
+func (r *MemoryDBRule) compile() (err error) {
+	if len(r.Outputs) == 0 {
+		err = errors.Errorf("memory db rule outputs required, sql:%s", r.SQL)
+		return err
+	}
+	switch r.Method {
+	case "", RECORD_METHOD_EXEC_OR_QUERY, RECORD_METHOD_NAMED, RECORD_METHOD_QUERY, RECORD_METHOD_EXEC:
+	default:
+		err = errors.Errorf("unsupported memory db rule method:%s, sql:%s", r.Method, r.SQL)
+		return err
+	}
+	switch r.Match {
+	case "", MATCH_TYPE_SPACE, MATCH_TYPE_EXACT, MATCH_TYPE_PARAM:
+	case MATCH_TYPE_REGEXP:
+		r.regexp, err = regexp.Compile(r.SQL)
+	case MATCH_TYPE_GLOB:
+		pattern := regexp.QuoteMeta(NormalizeSQL(r.SQL))
+		pattern = strings.ReplaceAll(pattern, `\*`, `.*`)
+		pattern = strings.ReplaceAll(pattern, `\?`, `.`)
+		r.regexp, err = regexp.Compile("^" + pattern + "$")
+	default:
+		err = errors.Errorf("unsupported memory db rule match:%s", r.Match)
+	}
+	if err != nil {
+		err = errors.WithMessagef(err, "memory db rule sql:%s", r.SQL)
+		return err
+	}
+	return nil
+}
+
+func (r *MemoryDBRule) matched(sql string) bool {
+	switch r.Match {
+	case MATCH_TYPE_EXACT:
+		return sql == r.SQL
+	case MATCH_TYPE_REGEXP, MATCH_TYPE_GLOB:
+		return r.regexp.MatchString(NormalizeSQL(sql))
+	case MATCH_TYPE_PARAM:
+		return NormalizeSQLParams(sql) == NormalizeSQLParams(r.SQL)
+	}
+	return NormalizeSQL(sql) == NormalizeSQL(r.SQL)
+}
+
+func (r *MemoryDBRule) call(method string, sql string) (out string, ok bool) {
+	if r.Times > 0 && r.calls >= r.Times {
+		return "", false
+	}
+	if r.Method != "" && r.Method != method {
+		return "", false
+	}
+	if !r.matched(sql) {
+		return "", false
+	}
+	index := r.calls
+	if index >= len(r.Outputs) {
+		index = len(r.Outputs) - 1
+	}
+	r.calls++
+	return r.Outputs[index], true
+}
+
+var (
+	sqlStringLiteralRegexp = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.)*"`)
+	sqlNumberLiteralRegexp = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	sqlPlaceholderList     = regexp.MustCompile(`\?(?:\s*,\s*\?)+`)
+)
+
+// NormalizeSQLParams 格式化sql并将字面量替换为 ?,多个连续 ? 合并为一个,用于忽略参数值的匹配
+func NormalizeSQLParams(sql string) string {
+	sql = NormalizeSQL(sql)
+	sql = sqlStringLiteralRegexp.ReplaceAllString(sql, "?")
+	sql = sqlNumberLiteralRegexp.ReplaceAllString(sql, "?")
+	sql = sqlPlaceholderList.ReplaceAllString(sql, "?")
+	return sql
 }
 
 func (m *TengoMemoryDB) TengoExecOrQueryContext(args ...tengo.Object) (ret tengo.Object, err error) {
@@ -57,8 +268,57 @@ func (m *TengoMemoryDB) TengoExecOrQueryContext(args ...tengo.Object) (ret tengo
 	return ret, err
 }
 
+// TengoExecOrQueryNamed 按模板驱动内联参数后回放,与 TengoRecordDB 录制的键一致
+func (m *TengoMemoryDB) TengoExecOrQueryNamed(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, tplOut, err := parseCtxTplOutArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	sql, err := tplOut.InlineSQL()
+	if err != nil {
+		return nil, err
+	}
+	out, err := m.ExecOrQueryNamedContext(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	return &tengo.String{Value: out}, nil
+}
+
+// ExecOrQueryNamedContext 回放 execOrQueryNamed 的录制结果,sql 为按模板驱动内联参数后的语句
+func (m *TengoMemoryDB) ExecOrQueryNamedContext(ctx context.Context, sql string) (out string, err error) {
+	return m.lookup(RECORD_METHOD_NAMED, sql)
+}
+
+// TengoQuery 返回值同 TengoDB.TengoQuery
+func (m *TengoMemoryDB) TengoQuery(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, sql, err := parseCtxSQLArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	records, err := m.QueryContext(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	return RecordsToTengo(records)
+}
+
+// TengoExec 返回值同 TengoDB.TengoExec
+func (m *TengoMemoryDB) TengoExec(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, sql, err := parseCtxSQLArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	results, err := m.ExecContext(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	return ExecResultsToTengo(results), nil
+}
+
+// BeginTx 参数同 TengoDB.BeginTx,事务选项记录在 TengoMemoryTx.Options 中,只读事务内执行非查询语句返回错误
 func (m *TengoMemoryDB) BeginTx(args ...tengo.Object) (ret tengo.Object, err error) {
-	if len(args) != 2 {
+	if len(args) != 1 && len(args) != 2 {
 		return nil, tengo.ErrWrongNumArguments
 	}
 	ctxObjPossible := args[0]
@@ -70,31 +330,257 @@ func (m *TengoMemoryDB) BeginTx(args ...tengo.Object) (ret tengo.Object, err err
 			Found:    ctxObjPossible.TypeName(),
 		}
 	}
-	_ = ctxObj.Context
+	var opts *stdsql.TxOptions
+	if len(args) == 2 {
+		opts, err = ParseTxOptions(args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	tx := newTengoMemoryTx(m)
+	tx.Options = opts
+	tx.tracker = txTrackerFrom(ctxObj.Context)
+	tx.tracker.add(tx)
+	return tx, nil
+}
+
+// Transactions 获取所有开启过的事务,可用于断言事务内执行的语句及提交、回滚状态
+func (m *TengoMemoryDB) Transactions() (txs []*TengoMemoryTx) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	txs = make([]*TengoMemoryTx, len(m.txs))
+	copy(txs, m.txs)
+	return txs
+}
+
+const (
+	MEMORY_TX_STATUS_ACTIVE      = "active"
+	MEMORY_TX_STATUS_COMMITTED   = "committed"
+	MEMORY_TX_STATUS_ROLLED_BACK = "rolledBack"
+)
+
+// TengoMemoryTx 内存事务,与 TengoTx 提供相同的脚本方法,并记录事务内执行的语句
+type TengoMemoryTx struct {
+	tengo.ImmutableMap
+	ID         int
+	ParentID   int // 嵌套事务的上级事务ID,顶级事务为0
+	Options    *stdsql.TxOptions
+	Statements []string
+	Status     string
+	db         *TengoMemoryDB
+	tracker    *TxTracker // 顶级事务所在 ctx 携带的 TxTracker
+}
+
+func (t *TengoMemoryTx) TypeName() string {
+	return "memory_db-tx"
+}
+func (t *TengoMemoryTx) String() string {
+	return ""
+}
+
+func newTengoMemoryTx(db *TengoMemoryDB) (t *TengoMemoryTx) {
+	t = &TengoMemoryTx{
+		ImmutableMap: tengo.ImmutableMap{
+			Value: make(map[string]tengo.Object),
+		},
+		Statements: make([]string, 0),
+		Status:     MEMORY_TX_STATUS_ACTIVE,
+		db:         db,
+	}
+	db.lock.Lock()
+	t.ID = len(db.txs) + 1
+	db.txs = append(db.txs, t)
+	db.lock.Unlock()
+
+	methods := map[string]tengo.CallableFunc{
+		"beginTx":            t.BeginTx,
+		"commit":             t.Commit,
+		"execOrQueryContext": t.ExecOrQueryContext,
+		"execOrQueryNamed":   t.ExecOrQueryNamed,
+		"query":              t.Query,
+		"exec":               t.Exec,
+		"rollback":           t.Rollback,
+		"finish":             TengoFinishTx(t),
+	}
+	for name, fn := range methods {
+		t.Value[name] = &tengo.UserFunction{
+			Name:  name,
+			Value: fn,
+		}
+	}
+	return t
+}
+
+func (t *TengoMemoryTx) ExecOrQueryContext(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, sql, err := parseCtxSQLArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	err = t.addStatement(sql)
+	if err != nil {
+		return nil, err
+	}
+	out, err := t.db.ExecOrQueryContext(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	ret = &tengo.String{Value: out}
+	return ret, nil
+}
+
+// ExecOrQueryNamed 事务内回放模板输出,语句记录为内联参数后的sql
+func (t *TengoMemoryTx) ExecOrQueryNamed(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, tplOut, err := parseCtxTplOutArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	sql, err := tplOut.InlineSQL()
+	if err != nil {
+		return nil, err
+	}
+	err = t.addStatement(sql)
+	if err != nil {
+		return nil, err
+	}
+	out, err := t.db.ExecOrQueryNamedContext(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	return &tengo.String{Value: out}, nil
+}
+
+// Query 事务内回放 query,返回值同 TengoDB.TengoQuery
+func (t *TengoMemoryTx) Query(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, sql, err := parseCtxSQLArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	err = t.addStatement(sql)
+	if err != nil {
+		return nil, err
+	}
+	records, err := t.db.QueryContext(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	return RecordsToTengo(records)
+}
+
+// Exec 事务内回放 exec,返回值同 TengoDB.TengoExec
+func (t *TengoMemoryTx) Exec(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, sql, err := parseCtxSQLArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	err = t.addStatement(sql)
+	if err != nil {
+		return nil, err
+	}
+	results, err := t.db.ExecContext(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	return ExecResultsToTengo(results), nil
+}
 
-	dbObjPossible := args[1]
-	_, ok = dbObjPossible.(*TengoMemoryDB)
+// addStatement 记录事务内执行的语句,事务已结束返回 sql.ErrTxDone,只读事务内执行非查询语句返回错误
+func (t *TengoMemoryTx) addStatement(sql string) (err error) {
+	t.db.lock.Lock()
+	status := t.Status
+	if status == MEMORY_TX_STATUS_ACTIVE {
+		t.Statements = append(t.Statements, sql)
+	}
+	t.db.lock.Unlock()
+	if status != MEMORY_TX_STATUS_ACTIVE {
+		return stdsql.ErrTxDone
+	}
+	if t.Options != nil && t.Options.ReadOnly && SQLType(NormalizeSQL(sql)) != SQL_TYPE_SELECT {
+		err = errors.Errorf("cannot execute statement in a read-only transaction:%s", sql)
+		return err
+	}
+	return nil
+}
+
+// BeginTx 开启嵌套事务,对应 TengoTx 的保存点
+func (t *TengoMemoryTx) BeginTx(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 1 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	ctxObjPossible := args[0]
+	_, ok := ctxObjPossible.(*tengocontext.TengoContext)
 	if !ok {
 		return nil, tengo.ErrInvalidArgumentType{
-			Name:     "db",
-			Expected: "db",
-			Found:    dbObjPossible.TypeName(),
+			Name:     "context",
+			Expected: "context.Context",
+			Found:    ctxObjPossible.TypeName(),
 		}
 	}
-	tx := &tengo.String{Value: "memoryDB"}
-	return tx, err
+	nested := newTengoMemoryTx(t.db)
+	nested.ParentID = t.ID
+	nested.Options = t.Options
+	return nested, nil
 }
 
-func NewTengoMemoryDB(config string) (tengoMemoryDB *TengoMemoryDB, err error) {
+func (t *TengoMemoryTx) Commit(args ...tengo.Object) (ret tengo.Object, err error) {
+	err = t.finish(MEMORY_TX_STATUS_COMMITTED)
+	return nil, err
+}
+
+func (t *TengoMemoryTx) Rollback(args ...tengo.Object) (ret tengo.Object, err error) {
+	err = t.finish(MEMORY_TX_STATUS_ROLLED_BACK)
+	return nil, err
+}
 
+// finish 结束事务,重复提交或回滚时与 database/sql 一致返回 sql.ErrTxDone
+func (t *TengoMemoryTx) finish(status string) (err error) {
+	t.db.lock.Lock()
+	defer t.db.lock.Unlock()
+	if t.Status != MEMORY_TX_STATUS_ACTIVE {
+		return stdsql.ErrTxDone
+	}
+	t.Status = status
+	t.tracker.remove(t)
+	return nil
+}
+
+// MemoryDBConfig 内存数据库配置
+type MemoryDBConfig struct {
+	Fixture string `json:"fixture"` // 录制文件路径,由 TengoRecordDB 生成
+}
+
+// NewTengoMemoryDB config 为空时创建空实例,否则按 MemoryDBConfig 加载录制文件
+func NewTengoMemoryDB(config string) (tengoMemoryDB *TengoMemoryDB, err error) {
 	tengoMemoryDB = &TengoMemoryDB{
 		ImmutableMap: tengo.ImmutableMap{
 			Value: make(map[string]tengo.Object),
 		},
+		InOutMap: make(map[string]string),
+	}
+	if config != "" {
+		cfg := &MemoryDBConfig{}
+		err = json.Unmarshal([]byte(config), cfg)
+		if err != nil {
+			err = errors.WithMessagef(err, "memory db config:%s", config)
+			return nil, err
+		}
+		if cfg.Fixture != "" {
+			fixture, err := LoadFixture(cfg.Fixture)
+			if err != nil {
+				return nil, err
+			}
+			tengoMemoryDB.InOutMap = fixture.InOutMap
+			err = tengoMemoryDB.AddRule(fixture.Rules...)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	//注入tengo 脚本方法
 	methods := map[string]tengo.CallableFunc{
 		"execOrQueryContext": tengoMemoryDB.TengoExecOrQueryContext,
+		"execOrQueryNamed":   tengoMemoryDB.TengoExecOrQueryNamed,
+		"query":              tengoMemoryDB.TengoQuery,
+		"exec":               tengoMemoryDB.TengoExec,
 		"beginTx":            tengoMemoryDB.BeginTx,
 	}
 
@@ -0,0 +1,365 @@
+package tengodb
+
+import (
+	"context"
+	stdsql "database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d5/tengo/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/tengolib/tengocontext"
+	"github.com/suifengpiao14/tengolib/tengotemplate"
+)
+
+func TestTengoMemoryDBTransaction(t *testing.T) {
+	memoryDB, err := NewTengoMemoryDB("")
+	require.NoError(t, err)
+	memoryDB.InOutMap = map[string]string{
+		"update user set name='a' where id=1": "1",
+		"select name from user where id=1":    "a",
+	}
+	script := tengo.NewScript([]byte(`
+	tx:=db.beginTx(ctx)
+	tx.execOrQueryContext(ctx,"update user set name='a' where id=1")
+	name:=tx.execOrQueryContext(ctx,"select name from user where id=1")
+	tx.commit()
+	tx2:=db.beginTx(ctx)
+	tx2.rollback()
+	`))
+	require.NoError(t, script.Add("db", memoryDB))
+	require.NoError(t, script.Add("ctx", &tengocontext.TengoContext{}))
+	c, err := script.Run()
+	require.NoError(t, err)
+	require.Equal(t, "a", c.Get("name").String())
+
+	txs := memoryDB.Transactions()
+	require.Len(t, txs, 2)
+	require.Equal(t, MEMORY_TX_STATUS_COMMITTED, txs[0].Status)
+	require.Equal(t, []string{"update user set name='a' where id=1", "select name from user where id=1"}, txs[0].Statements)
+	require.Equal(t, MEMORY_TX_STATUS_ROLLED_BACK, txs[1].Status)
+	_, err = txs[1].Commit()
+	require.Error(t, err)
+}
+
+func TestNewTengoMemoryDBFixture(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "fixture.json")
+	r := &TengoRecordDB{fixture: filename, inOutMap: make(map[string]string), sequences: make(map[string]*recordSequence)}
+	r.record(RECORD_METHOD_EXEC_OR_QUERY, "select name\n  from user where id=1", "a")
+	require.NoError(t, r.Save())
+
+	memoryDB, err := NewTengoMemoryDB(fmt.Sprintf(`{"fixture":%q}`, filename))
+	require.NoError(t, err)
+	out, err := memoryDB.ExecOrQueryContext(context.Background(), "select name from user\n where id=1")
+	require.NoError(t, err)
+	require.Equal(t, "a", out)
+}
+
+func TestTengoMemoryDBRule(t *testing.T) {
+	memoryDB, err := NewTengoMemoryDB("")
+	require.NoError(t, err)
+	paramRule := &MemoryDBRule{
+		Match:   MATCH_TYPE_PARAM,
+		SQL:     "insert into user (id,name,created_at) values ('x1','a','2023-01-01 00:00:00')",
+		Outputs: []string{"1", "2"},
+	}
+	err = memoryDB.AddRule(
+		paramRule,
+		&MemoryDBRule{Match: MATCH_TYPE_GLOB, SQL: "select * from user where id in (*)", Outputs: []string{"[]"}, Times: 1},
+		&MemoryDBRule{Match: MATCH_TYPE_REGEXP, SQL: `^select count\(\*\) from user`, Outputs: []string{"10"}},
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	out, err := memoryDB.ExecOrQueryContext(ctx, "insert into user (id,name,created_at)\n values ('cfq2','b','2023-05-06 12:00:00')")
+	require.NoError(t, err)
+	require.Equal(t, "1", out)
+	out, err = memoryDB.ExecOrQueryContext(ctx, "insert into user (id,name,created_at) values ('cfq3','it''s','2023-05-06 12:00:01')")
+	require.NoError(t, err)
+	require.Equal(t, "2", out)
+	out, err = memoryDB.ExecOrQueryContext(ctx, "insert into user (id,name,created_at) values ('cfq4','c','2023-05-06 12:00:02')")
+	require.NoError(t, err)
+	require.Equal(t, "2", out)
+	require.Equal(t, 3, paramRule.Calls())
+
+	out, err = memoryDB.ExecOrQueryContext(ctx, "select * from user where id in (1,2,3)")
+	require.NoError(t, err)
+	require.Equal(t, "[]", out)
+	_, err = memoryDB.ExecOrQueryContext(ctx, "select * from user where id in (1,2,3)")
+	require.Error(t, err)
+
+	out, err = memoryDB.ExecOrQueryContext(ctx, "select count(*) from user where age>18")
+	require.NoError(t, err)
+	require.Equal(t, "10", out)
+
+	require.Equal(t, "select * from user where id in (?) and name=?", NormalizeSQLParams("select * from user where id in (1, 2,3) and name='a'"))
+}
+
+func TestTengoMemoryDBRuleMethod(t *testing.T) {
+	memoryDB, err := NewTengoMemoryDB("")
+	require.NoError(t, err)
+	err = memoryDB.AddRule(
+		&MemoryDBRule{Match: MATCH_TYPE_GLOB, Method: RECORD_METHOD_QUERY, SQL: "select id,name from user where id in (*)", Outputs: []string{`[{"id":1,"name":"a"}]`}},
+		&MemoryDBRule{Match: MATCH_TYPE_REGEXP, Method: RECORD_METHOD_EXEC, SQL: `^delete from user`, Outputs: []string{`[{"lastInsertId":0,"rowsAffected":2}]`}},
+		&MemoryDBRule{Match: MATCH_TYPE_PARAM, Method: RECORD_METHOD_EXEC, SQL: "insert into user (name) values ('a')", Outputs: []string{`[{"lastInsertId":3,"rowsAffected":1}]`}},
+		&MemoryDBRule{Method: RECORD_METHOD_QUERY, SQL: "select count(*) as c from user", Outputs: []string{`[{"c":2}]`}},
+	)
+	require.NoError(t, err)
+	require.Error(t, memoryDB.AddRule(&MemoryDBRule{Method: "select", SQL: "select 1", Outputs: []string{"1"}}))
+
+	script := tengo.NewScript([]byte(`
+	rows:=memoryDB.query(ctx,"select id,name from user where id in (1,2)")
+	deleted:=memoryDB.exec(ctx,"delete from user where id=1")
+	inserted:=memoryDB.exec(ctx,"insert into user (name) values ('b')")
+	count:=memoryDB.query(ctx,"select  count(*) as c\n from user")
+	`))
+	require.NoError(t, script.Add("memoryDB", memoryDB))
+	require.NoError(t, script.Add("ctx", tengocontext.NewTengoContext(context.Background())))
+	c, err := script.Run()
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{map[string]interface{}{"id": int64(1), "name": "a"}}, tengo.ToInterface(c.Get("rows").Object()))
+	require.Equal(t, int64(2), tengo.ToInterface(c.Get("deleted").Object()).(map[string]interface{})["rowsAffected"])
+	require.Equal(t, int64(3), tengo.ToInterface(c.Get("inserted").Object()).(map[string]interface{})["lastInsertId"])
+	require.Equal(t, []interface{}{map[string]interface{}{"c": int64(2)}}, tengo.ToInterface(c.Get("count").Object()))
+
+	// 规则限定方法,execOrQueryContext 不命中 query 规则
+	_, err = memoryDB.ExecOrQueryContext(context.Background(), "select count(*) as c from user")
+	require.Error(t, err)
+}
+
+func TestTransactionModule(t *testing.T) {
+	memoryDB, err := NewTengoMemoryDB("")
+	require.NoError(t, err)
+	memoryDB.InOutMap = map[string]string{
+		"update user set name='a' where id=1": "1",
+		"update user set name='b' where id=2": "1",
+	}
+	script := tengo.NewScript([]byte(`
+	db:=import("db")
+	ok:=db.Transaction(ctx,memoryDB,func(tx){
+		tx.execOrQueryContext(ctx,"update user set name='a' where id=1")
+		nested:=db.Transaction(ctx,tx,func(tx){
+			tx.execOrQueryContext(ctx,"update user set name='b' where id=2")
+			return error("rollback nested")
+		})
+		return is_error(nested)
+	})
+	`))
+	modules := tengo.NewModuleMap()
+	modules.AddSourceModule("db", []byte(TengoDBSource))
+	script.SetImports(modules)
+	require.NoError(t, script.Add("memoryDB", memoryDB))
+	require.NoError(t, script.Add("ctx", &tengocontext.TengoContext{}))
+	c, err := script.Run()
+	require.NoError(t, err)
+	require.True(t, c.Get("ok").Bool())
+
+	txs := memoryDB.Transactions()
+	require.Len(t, txs, 2)
+	require.Equal(t, MEMORY_TX_STATUS_COMMITTED, txs[0].Status)
+	require.Equal(t, MEMORY_TX_STATUS_ROLLED_BACK, txs[1].Status)
+	require.Equal(t, txs[0].ID, txs[1].ParentID)
+}
+
+func TestTransactionModuleCommitError(t *testing.T) {
+	memoryDB, err := NewTengoMemoryDB("")
+	require.NoError(t, err)
+	script := tengo.NewScript([]byte(`
+	db:=import("db")
+	result:=db.Transaction(ctx,memoryDB,func(tx){
+		tx.commit()
+		return "done"
+	})
+	failed:=is_error(result)
+	`))
+	modules := tengo.NewModuleMap()
+	modules.AddSourceModule("db", []byte(TengoDBSource))
+	script.SetImports(modules)
+	require.NoError(t, script.Add("memoryDB", memoryDB))
+	require.NoError(t, script.Add("ctx", &tengocontext.TengoContext{}))
+	c, err := script.Run()
+	require.NoError(t, err)
+	require.True(t, c.Get("failed").Bool())
+}
+
+func TestTransactionRuntimeErrorRollback(t *testing.T) {
+	tengoDB, err := newTengoDB(DBConfig{DSN: ":memory:", Driver: "sqlite3", MaxOpenConns: 1})
+	require.NoError(t, err)
+	t.Cleanup(func() { tengoDB.Close() })
+	_, err = tengoDB.GetDB().Exec("create table user (id INTEGER PRIMARY KEY, name TEXT)")
+	require.NoError(t, err)
+	memoryDB, err := NewTengoMemoryDB("")
+	require.NoError(t, err)
+	script := tengo.NewScript([]byte(`
+	db:=import("db")
+	db.Transaction(ctx,memoryDB,func(tx){
+		return "ok"
+	})
+	db.Transaction(ctx,tengoDB,func(tx){
+		tx.exec(ctx,"insert into user (name) values ('a')")
+		db.Transaction(ctx,memoryDB,func(tx){
+			arr:=[1]
+			arr[3]=2
+		})
+	})
+	`))
+	modules := tengo.NewModuleMap()
+	modules.AddSourceModule("db", []byte(TengoDBSource))
+	script.SetImports(modules)
+	ctx, tracker := WithTxTracker(context.Background())
+	require.NoError(t, script.Add("tengoDB", tengoDB))
+	require.NoError(t, script.Add("memoryDB", memoryDB))
+	require.NoError(t, script.Add("ctx", tengocontext.NewTengoContext(ctx)))
+	_, err = script.RunContext(ctx)
+	require.Error(t, err)
+	require.Len(t, tracker.Open(), 2)
+	require.NoError(t, tracker.RollbackOpen())
+	require.Empty(t, tracker.Open())
+
+	// 连接池只有一个连接,事务回滚归还连接后才能查询,ctx 始终未取消
+	queryCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	records, err := QueryContext(queryCtx, tengoDB.GetDB(), "select count(*) as c from user")
+	require.NoError(t, err)
+	require.Equal(t, []map[string]any{{"c": int64(0)}}, records)
+	txs := memoryDB.Transactions()
+	require.Len(t, txs, 2)
+	require.Equal(t, MEMORY_TX_STATUS_COMMITTED, txs[0].Status)
+	require.Equal(t, MEMORY_TX_STATUS_ROLLED_BACK, txs[1].Status)
+}
+
+func TestTransactionPanicRollback(t *testing.T) {
+	memoryDB, err := NewTengoMemoryDB("")
+	require.NoError(t, err)
+	require.Panics(t, func() {
+		Transaction(context.Background(), memoryDB, func(tx tengo.Object) (tengo.Object, error) {
+			panic("boom")
+		})
+	})
+	_, err = Transaction(context.Background(), memoryDB, func(tx tengo.Object) (tengo.Object, error) {
+		return &tengo.Error{Value: &tengo.String{Value: "rollback"}}, nil
+	})
+	require.Error(t, err)
+	ret, err := Transaction(context.Background(), memoryDB, func(tx tengo.Object) (tengo.Object, error) {
+		return &tengo.String{Value: "ok"}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "ok", ret.(*tengo.String).Value)
+
+	txs := memoryDB.Transactions()
+	require.Len(t, txs, 3)
+	require.Equal(t, MEMORY_TX_STATUS_ROLLED_BACK, txs[0].Status)
+	require.Equal(t, MEMORY_TX_STATUS_ROLLED_BACK, txs[1].Status)
+	require.Equal(t, MEMORY_TX_STATUS_COMMITTED, txs[2].Status)
+}
+
+func TestTengoMemoryDBTxOptions(t *testing.T) {
+	memoryDB, err := NewTengoMemoryDB("")
+	require.NoError(t, err)
+	memoryDB.InOutMap = map[string]string{
+		"select count(*) from stock": "10",
+		"update stock set num=9":     "1",
+	}
+	script := tengo.NewScript([]byte(`
+	tx:=db.beginTx(ctx,{isolation:"serializable",readOnly:true})
+	count:=tx.execOrQueryContext(ctx,"select count(*) from stock")
+	tx.execOrQueryContext(ctx,"update stock set num=9")
+	`))
+	require.NoError(t, script.Add("db", memoryDB))
+	require.NoError(t, script.Add("ctx", &tengocontext.TengoContext{}))
+	_, err = script.Run()
+	require.Error(t, err)
+
+	txs := memoryDB.Transactions()
+	require.Len(t, txs, 1)
+	require.Equal(t, &stdsql.TxOptions{Isolation: stdsql.LevelSerializable, ReadOnly: true}, txs[0].Options)
+
+	level, err := ParseIsolationLevel("READ_COMMITTED")
+	require.NoError(t, err)
+	require.Equal(t, stdsql.LevelReadCommitted, level)
+	_, err = ParseIsolationLevel("dirty")
+	require.Error(t, err)
+}
+
+func TestTengoRecordDBReplay(t *testing.T) {
+	tpl := tengotemplate.NewTemplate()
+	tpl.AddTpl("addUser", "insert into user (name) values (:name)")
+	src := []byte(`
+	before := db.execOrQueryContext(ctx, "select count(*) as c from user")
+	added := db.exec(ctx, "insert into user (name) values ('a')")
+	db.execOrQueryNamed(ctx, tpl.exec("addUser", {name: "O'Brien"}))
+	tx := db.beginTx(ctx)
+	tx.execOrQueryNamed(ctx, tpl.exec("addUser", {name: "D'Arcy"}))
+	nested := tx.beginTx(ctx)
+	renamed := nested.exec(ctx, "update user set name='b' where id=1")
+	nested.commit()
+	users := tx.query(ctx, "select id,name from user order by id")
+	count := tx.execOrQueryContext(ctx, "select count(*) as c from user")
+	tx.commit()
+	`)
+	run := func(db tengo.Object) map[string]interface{} {
+		script := tengo.NewScript(src)
+		require.NoError(t, script.Add("db", db))
+		require.NoError(t, script.Add("tpl", tpl))
+		require.NoError(t, script.Add("ctx", tengocontext.NewTengoContext(context.Background())))
+		c, err := script.Run()
+		require.NoError(t, err)
+		vars := make(map[string]interface{})
+		for _, name := range []string{"before", "added", "renamed", "users", "count"} {
+			vars[name] = tengo.ToInterface(c.Get(name).Object())
+		}
+		return vars
+	}
+
+	filename := filepath.Join(t.TempDir(), "fixture.json")
+	recordDB, err := NewTengoRecordDB(newRecordTengoDB(t), filename)
+	require.NoError(t, err)
+	recorded := run(recordDB)
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"id": int64(1), "name": "b"},
+		map[string]interface{}{"id": int64(2), "name": "O'Brien"},
+		map[string]interface{}{"id": int64(3), "name": "D'Arcy"},
+	}, recorded["users"])
+	require.NotEqual(t, recorded["before"], recorded["count"])
+	fixture := recordDB.Fixture()
+	require.Contains(t, fixture.InOutMap, RecordKey(RECORD_METHOD_NAMED, `insert into user (name) values ('O\'Brien')`))
+	require.NotContains(t, fixture.InOutMap, "select count(*) as c from user")
+	require.Len(t, fixture.Rules, 1)
+	require.Equal(t, []string{recorded["before"].(string), recorded["count"].(string)}, fixture.Rules[0].Outputs)
+	require.NoError(t, recordDB.Save())
+
+	memoryDB, err := NewTengoMemoryDB(fmt.Sprintf(`{"fixture":%q}`, filename))
+	require.NoError(t, err)
+	require.Equal(t, recorded, run(memoryDB))
+	txs := memoryDB.Transactions()
+	require.Len(t, txs, 2)
+	require.Equal(t, MEMORY_TX_STATUS_COMMITTED, txs[0].Status)
+	require.Equal(t, []string{"update user set name='b' where id=1"}, txs[1].Statements)
+}
+
+func TestTengoMemoryDBRuleConcurrent(t *testing.T) {
+	memoryDB, err := NewTengoMemoryDB("")
+	require.NoError(t, err)
+	rule := &MemoryDBRule{Match: MATCH_TYPE_PARAM, SQL: "select name from user where id=1", Outputs: []string{"a"}}
+	require.NoError(t, memoryDB.AddRule(rule))
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			_, err := memoryDB.ExecOrQueryContext(context.Background(), fmt.Sprintf("select name from user where id=%d", id))
+			errs <- err
+			rule.Calls()
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, 10, rule.Calls())
+}
@@ -0,0 +1,294 @@
+package tengodb
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/d5/tengo/v2"
+	"github.com/pkg/errors"
+)
+
+// MemoryDBFixture 录制文件格式,InOutMap 键为 RecordKey(方法,sql),值为执行输出;同一键录制到不同输出时以按顺序返回的规则保存在 Rules 中,Rules 也可手工添加,用于模糊匹配
+type MemoryDBFixture struct {
+	InOutMap map[string]string `json:"inOutMap"`
+	Rules    []*MemoryDBRule   `json:"rules,omitempty"`
+}
+
+// LoadFixture 读取录制文件
+func LoadFixture(filename string) (fixture *MemoryDBFixture, err error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		err = errors.WithMessagef(err, "fixture:%s", filename)
+		return nil, err
+	}
+	fixture = &MemoryDBFixture{}
+	err = json.Unmarshal(b, fixture)
+	if err != nil {
+		err = errors.WithMessagef(err, "fixture:%s", filename)
+		return nil, err
+	}
+	if fixture.InOutMap == nil {
+		fixture.InOutMap = make(map[string]string)
+	}
+	return fixture, nil
+}
+
+// TengoRecordDB 包装 TengoDB,记录 query、exec、execOrQueryNamed、execOrQueryContext(含事务内)格式化后的sql及输出,Save 后可由 NewTengoMemoryDB 加载回放
+type TengoRecordDB struct {
+	tengo.ImmutableMap
+	tengoDB      *TengoDB
+	fixture      string
+	inOutMap     map[string]string
+	rules        []*MemoryDBRule // 保留已有录制文件中的规则
+	sequences    map[string]*recordSequence
+	sequenceKeys []string // 按首次录制的顺序
+	lock         sync.Mutex
+}
+
+// recordSequence 本次录制中同一键依次得到的输出
+type recordSequence struct {
+	method  string
+	sql     string
+	outputs []string
+}
+
+func (r *TengoRecordDB) TypeName() string {
+	return "record_db"
+}
+func (r *TengoRecordDB) String() string {
+	return ""
+}
+
+// NewTengoRecordDB fixture 文件已存在时,在原有记录基础上追加
+func NewTengoRecordDB(tengoDB *TengoDB, fixture string) (r *TengoRecordDB, err error) {
+	r = &TengoRecordDB{
+		ImmutableMap: tengo.ImmutableMap{
+			Value: make(map[string]tengo.Object),
+		},
+		tengoDB:   tengoDB,
+		fixture:   fixture,
+		inOutMap:  make(map[string]string),
+		sequences: make(map[string]*recordSequence),
+	}
+	if _, statErr := os.Stat(fixture); statErr == nil {
+		old, err := LoadFixture(fixture)
+		if err != nil {
+			return nil, err
+		}
+		r.inOutMap = old.InOutMap
+		r.rules = old.Rules
+	}
+	for key, method := range tengoDB.Value {
+		r.Value[key] = method
+	}
+	//注入tengo 脚本方法
+	methods := r.recordMethods(tengoDB.sqlDB, tengoDB.driverName)
+	methods["beginTx"] = r.BeginTx
+	for key, method := range methods {
+		r.Value[key] = &tengo.UserFunction{
+			Name:  key,
+			Value: method,
+		}
+	}
+	return r, nil
+}
+
+func (r *TengoRecordDB) ExecOrQueryContext(ctx context.Context, sql string) (out string, err error) {
+	out, err = r.tengoDB.ExecOrQueryContext(ctx, sql)
+	if err != nil {
+		return "", err
+	}
+	r.record(RECORD_METHOD_EXEC_OR_QUERY, sql, out)
+	return out, nil
+}
+
+func (r *TengoRecordDB) TengoExecOrQueryContext(args ...tengo.Object) (ret tengo.Object, err error) {
+	ctx, sql, err := parseCtxSQLArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	out, err := r.ExecOrQueryContext(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	ret = &tengo.String{Value: out}
+	return ret, nil
+}
+
+// BeginTx 开启真实事务,事务(含嵌套事务)内的数据方法同样被记录
+func (r *TengoRecordDB) BeginTx(args ...tengo.Object) (ret tengo.Object, err error) {
+	txObj, err := r.tengoDB.BeginTx(args...)
+	if err != nil {
+		return nil, err
+	}
+	tx := txObj.(*TengoTx)
+	r.wrapTx(tx)
+	return tx, nil
+}
+
+func (r *TengoRecordDB) wrapTx(tx *TengoTx) {
+	methods := r.recordMethods(tx.sqlTx, tx.driverName)
+	methods["beginTx"] = func(args ...tengo.Object) (ret tengo.Object, err error) {
+		nestedObj, err := tx.BeginTx(args...)
+		if err != nil {
+			return nil, err
+		}
+		nested := nestedObj.(*TengoTx)
+		r.wrapTx(nested)
+		return nested, nil
+	}
+	for key, method := range methods {
+		tx.Value[key] = &tengo.UserFunction{
+			Name:  key,
+			Value: method,
+		}
+	}
+}
+
+// recordMethods 与 TengoDB、TengoTx 同名的数据方法,执行后记录输出,query、exec 的结果以 json 保存
+func (r *TengoRecordDB) recordMethods(exetor ExectorInterface, driverName string) map[string]tengo.CallableFunc {
+	return map[string]tengo.CallableFunc{
+		"execOrQueryContext": func(args ...tengo.Object) (ret tengo.Object, err error) {
+			ctx, sql, err := parseCtxSQLArgs(args...)
+			if err != nil {
+				return nil, err
+			}
+			out, err := ExecOrQueryContext(ctx, exetor, sql)
+			if err != nil {
+				return nil, err
+			}
+			r.record(RECORD_METHOD_EXEC_OR_QUERY, sql, out)
+			return &tengo.String{Value: out}, nil
+		},
+		"execOrQueryNamed": func(args ...tengo.Object) (ret tengo.Object, err error) {
+			ctx, tplOut, err := parseCtxTplOutArgs(args...)
+			if err != nil {
+				return nil, err
+			}
+			out, err := ExecOrQueryNamedContext(ctx, exetor, driverName, tplOut.Out, tplOut.Data)
+			if err != nil {
+				return nil, err
+			}
+			sql, err := tplOut.InlineSQL()
+			if err != nil {
+				return nil, err
+			}
+			r.record(RECORD_METHOD_NAMED, sql, out)
+			return &tengo.String{Value: out}, nil
+		},
+		"query": func(args ...tengo.Object) (ret tengo.Object, err error) {
+			ctx, sql, err := parseCtxSQLArgs(args...)
+			if err != nil {
+				return nil, err
+			}
+			records, err := QueryContext(ctx, exetor, sql)
+			if err != nil {
+				return nil, err
+			}
+			b, err := json.Marshal(records)
+			if err != nil {
+				return nil, err
+			}
+			r.record(RECORD_METHOD_QUERY, sql, string(b))
+			return RecordsToTengo(records)
+		},
+		"exec": func(args ...tengo.Object) (ret tengo.Object, err error) {
+			ctx, sql, err := parseCtxSQLArgs(args...)
+			if err != nil {
+				return nil, err
+			}
+			results, err := ExecContextByDriver(ctx, exetor, driverName, sql)
+			if err != nil {
+				return nil, err
+			}
+			b, err := json.Marshal(results)
+			if err != nil {
+				return nil, err
+			}
+			r.record(RECORD_METHOD_EXEC, sql, string(b))
+			return ExecResultsToTengo(results), nil
+		},
+	}
+}
+
+const (
+	RECORD_METHOD_EXEC_OR_QUERY = "execOrQueryContext"
+	RECORD_METHOD_NAMED         = "execOrQueryNamed"
+	RECORD_METHOD_QUERY         = "query"
+	RECORD_METHOD_EXEC          = "exec"
+)
+
+// RecordKey 录制文件的键,execOrQueryContext 为格式化后的sql,其它方法加上 "方法:" 前缀;execOrQueryNamed 的sql为按模板驱动内联参数后的语句
+func RecordKey(method string, sql string) string {
+	if method == RECORD_METHOD_EXEC_OR_QUERY {
+		return NormalizeSQL(sql)
+	}
+	return method + ":" + NormalizeSQL(sql)
+}
+
+func (r *TengoRecordDB) record(method string, sql string, out string) {
+	key := RecordKey(method, sql)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	sequence, ok := r.sequences[key]
+	if !ok {
+		sequence = &recordSequence{method: method, sql: NormalizeSQL(sql)}
+		r.sequences[key] = sequence
+		r.sequenceKeys = append(r.sequenceKeys, key)
+	}
+	sequence.outputs = append(sequence.outputs, out)
+}
+
+// Fixture 获取当前已录制的内容,本次录制的键覆盖已有录制文件中的同名键;同一键输出始终相同时保存在 InOutMap,否则保存为按调用顺序返回的规则
+func (r *TengoRecordDB) Fixture() (fixture *MemoryDBFixture) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	fixture = &MemoryDBFixture{
+		InOutMap: make(map[string]string, len(r.inOutMap)+len(r.sequences)),
+		Rules:    make([]*MemoryDBRule, 0, len(r.rules)),
+	}
+	for k, v := range r.inOutMap {
+		fixture.InOutMap[k] = v
+	}
+	for _, key := range r.sequenceKeys {
+		sequence := r.sequences[key]
+		if sequence.constant() {
+			fixture.InOutMap[key] = sequence.outputs[0]
+			continue
+		}
+		delete(fixture.InOutMap, key)
+		fixture.Rules = append(fixture.Rules, &MemoryDBRule{
+			Method:  sequence.method,
+			SQL:     sequence.sql,
+			Outputs: append([]string(nil), sequence.outputs...),
+		})
+	}
+	fixture.Rules = append(fixture.Rules, r.rules...)
+	return fixture
+}
+
+// constant 输出是否始终相同
+func (s *recordSequence) constant() bool {
+	for _, out := range s.outputs[1:] {
+		if out != s.outputs[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// Save 将录制内容写入 fixture 文件
+func (r *TengoRecordDB) Save() (err error) {
+	b, err := json.MarshalIndent(r.Fixture(), "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(r.fixture, b, 0644)
+	if err != nil {
+		err = errors.WithMessagef(err, "fixture:%s", r.fixture)
+		return err
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package tengogsjson
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -23,7 +24,7 @@ type Storage struct {
 func NewStorage() (m *Storage) {
 	m = &Storage{
 		Memory: &tengo.Map{},
-		Ctx:    &tengocontext.TengoContext{},
+		Ctx:    tengocontext.NewTengoContext(context.Background()),
 	}
 	m.Value = map[string]tengo.Object{
 		"Get": &tengo.UserFunction{
@@ -91,6 +92,11 @@ func NewStorage() (m *Storage) {
 	return m
 }
 
+// SetContext 设置 GetCtx 返回的上下文,一般传入宿主的请求上下文
+func (s *Storage) SetContext(ctx context.Context) {
+	s.Ctx = tengocontext.NewTengoContext(ctx)
+}
+
 func (s *Storage) TypeName() string {
 	return "gjson-Storage"
 }
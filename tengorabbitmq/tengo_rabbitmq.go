@@ -0,0 +1,230 @@
+package tengorabbitmq
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/d5/tengo/v2"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/tengolib/tengocontext"
+	"github.com/suifengpiao14/tengolib/tengosource"
+)
+
+// TengoRabbitMQ rabbitmq 资源,脚本中通过 publish(ctx,exchange,routingKey,body[,headers]) 投递消息
+type TengoRabbitMQ struct {
+	tengo.ImmutableMap
+	publisher Publisher
+	config    RabbitMQConfig
+}
+
+func (tengoRabbitMQ *TengoRabbitMQ) TypeName() string {
+	return "rabbitmq"
+}
+func (tengoRabbitMQ *TengoRabbitMQ) String() string {
+	return ""
+}
+
+// init 导入本包即注册 RABBITMQ 资源类型
+func init() {
+	tengosource.RegisterProviderFactory(tengosource.PROVIDER_RABBITMQ, func(config string) (tengosource.Provider, error) {
+		return NewTengoRabbitMQ(config)
+	})
+}
+
+// NewTengoRabbitMQ config 为 RabbitMQConfig 的 json 格式,transport 为 memory 时使用 MemoryPublisher
+func NewTengoRabbitMQ(config string) (tengoRabbitMQ *TengoRabbitMQ, err error) {
+	cfg := RabbitMQConfig{}
+	err = json.Unmarshal([]byte(config), &cfg)
+	if err != nil {
+		err = errors.WithMessagef(err, "rabbitmq config:%s", config)
+		return nil, err
+	}
+	var publisher Publisher
+	switch cfg.Transport {
+	case "", TRANSPORT_AMQP:
+		if cfg.URL == "" {
+			err = errors.Errorf("rabbitmq config url required:%s", config)
+			return nil, err
+		}
+		publisher = NewAMQPPublisher(cfg.URL)
+	case TRANSPORT_MEMORY:
+		publisher = NewMemoryPublisher()
+	default:
+		err = errors.Errorf("unsupported rabbitmq transport:%s", cfg.Transport)
+		return nil, err
+	}
+	return NewTengoRabbitMQWithPublisher(cfg, publisher)
+}
+
+// NewTengoRabbitMQWithPublisher 使用自定义传输层
+func NewTengoRabbitMQWithPublisher(cfg RabbitMQConfig, publisher Publisher) (tengoRabbitMQ *TengoRabbitMQ, err error) {
+	_, err = cfg.ParseTimeout()
+	if err != nil {
+		return nil, err
+	}
+	tengoRabbitMQ = &TengoRabbitMQ{
+		ImmutableMap: tengo.ImmutableMap{
+			Value: make(map[string]tengo.Object),
+		},
+		publisher: publisher,
+		config:    cfg,
+	}
+	//注入tengo 脚本方法
+	methods := map[string]tengo.CallableFunc{
+		"publish":            tengoRabbitMQ.TengoPublish,
+		"execOrQueryContext": tengoRabbitMQ.TengoExecOrQueryContext,
+	}
+	for key, method := range methods {
+		tengoRabbitMQ.Value[key] = &tengo.UserFunction{
+			Name:  key,
+			Value: method,
+		}
+	}
+	return tengoRabbitMQ, nil
+}
+
+// GetPublisher 获取传输层,如测试中取出 MemoryPublisher 检查投递的消息
+func (tengoRabbitMQ *TengoRabbitMQ) GetPublisher() Publisher {
+	return tengoRabbitMQ.publisher
+}
+
+// Close 关闭传输层
+func (tengoRabbitMQ *TengoRabbitMQ) Close() (err error) {
+	return tengoRabbitMQ.publisher.Close()
+}
+
+// Health 传输层实现 HealthChecker 时调用其检查,否则返回 nil
+func (tengoRabbitMQ *TengoRabbitMQ) Health(ctx context.Context) (err error) {
+	checker, ok := tengoRabbitMQ.publisher.(HealthChecker)
+	if !ok {
+		return nil
+	}
+	return checker.Health(ctx)
+}
+
+// textMessage execOrQueryContext 接收的消息文本,body 为 json 字符串时原样投递,其它 json 值以 json 格式投递
+type textMessage struct {
+	Exchange   string                 `json:"exchange"`
+	RoutingKey string                 `json:"routingKey"`
+	Body       json.RawMessage        `json:"body"`
+	Headers    map[string]interface{} `json:"headers"`
+}
+
+// ExecOrQueryContext 与 TengoDB 签名一致,text 为模板渲染的 json:{"exchange":"","routingKey":"","body":{},"headers":{}},投递成功返回空字符串
+func (tengoRabbitMQ *TengoRabbitMQ) ExecOrQueryContext(ctx context.Context, text string) (out string, err error) {
+	msg := textMessage{}
+	err = json.Unmarshal([]byte(text), &msg)
+	if err != nil {
+		err = errors.WithMessagef(err, "rabbitmq message:%s", text)
+		return "", err
+	}
+	body := []byte(msg.Body)
+	var s string
+	if json.Unmarshal(msg.Body, &s) == nil {
+		body = []byte(s)
+	}
+	err = tengoRabbitMQ.Publish(ctx, msg.Exchange, msg.RoutingKey, body, msg.Headers)
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// Publish 投递消息,exchange 为空时使用配置中的 exchange
+func (tengoRabbitMQ *TengoRabbitMQ) Publish(ctx context.Context, exchange string, routingKey string, body []byte, headers map[string]interface{}) (err error) {
+	msg := Message{
+		Exchange:   exchange,
+		RoutingKey: routingKey,
+		Body:       body,
+		Headers:    headers,
+	}
+	return Publish(ctx, tengoRabbitMQ.publisher, tengoRabbitMQ.config, msg)
+}
+
+// TengoPublish body 为字符串或 bytes 时原样投递,其它类型以 json 格式投递;headers 为可选的 map
+func (tengoRabbitMQ *TengoRabbitMQ) TengoPublish(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 4 && len(args) != 5 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	ctxObj, ok := args[0].(*tengocontext.TengoContext)
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "context",
+			Expected: "context.Context",
+			Found:    args[0].TypeName(),
+		}
+	}
+	exchange, ok := tengo.ToString(args[1])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "exchange",
+			Expected: "string",
+			Found:    args[1].TypeName(),
+		}
+	}
+	routingKey, ok := tengo.ToString(args[2])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "routingKey",
+			Expected: "string",
+			Found:    args[2].TypeName(),
+		}
+	}
+	var body []byte
+	switch o := args[3].(type) {
+	case *tengo.String:
+		body = []byte(o.Value)
+	case *tengo.Bytes:
+		body = o.Value
+	default:
+		body, err = json.Marshal(tengo.ToInterface(o))
+		if err != nil {
+			err = errors.WithMessagef(err, "body:%s", o.String())
+			return nil, err
+		}
+	}
+	var headers map[string]interface{}
+	if len(args) == 5 && args[4] != tengo.UndefinedValue {
+		headers, ok = tengo.ToInterface(args[4]).(map[string]interface{})
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{
+				Name:     "headers",
+				Expected: "map",
+				Found:    args[4].TypeName(),
+			}
+		}
+	}
+	err = tengoRabbitMQ.Publish(ctxObj.Context, exchange, routingKey, body, headers)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// TengoExecOrQueryContext execOrQueryContext(ctx,text) 投递模板渲染的 json 消息
+func (tengoRabbitMQ *TengoRabbitMQ) TengoExecOrQueryContext(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	ctxObj, ok := args[0].(*tengocontext.TengoContext)
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "context",
+			Expected: "context.Context",
+			Found:    args[0].TypeName(),
+		}
+	}
+	text, ok := tengo.ToString(args[1])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "text",
+			Expected: "string",
+			Found:    args[1].TypeName(),
+		}
+	}
+	_, err = tengoRabbitMQ.ExecOrQueryContext(ctxObj.Context, text)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
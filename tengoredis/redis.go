@@ -0,0 +1,188 @@
+package tengoredis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/suifengpiao14/logchan/v2"
+)
+
+type LogName string
+
+func (l LogName) String() string {
+	return string(l)
+}
+
+const (
+	LOG_INFO_REDIS LogName = "LogInfoRedis"
+)
+
+type LogInfoRedis struct {
+	Context  context.Context
+	Cmd      string    `json:"cmd"`
+	Args     []any     `json:"args"`
+	Reply    any       `json:"reply"`
+	Err      error     `json:"error"`
+	BeginAt  time.Time `json:"beginAt"`
+	EndAt    time.Time `json:"endAt"`
+	Duration string    `json:"time"`
+	logchan.EmptyLogInfo
+}
+
+func (l *LogInfoRedis) GetName() logchan.LogName {
+	return LOG_INFO_REDIS
+}
+func (l *LogInfoRedis) Error() error {
+	return l.Err
+}
+
+type any = interface{}
+
+// RedisConfig 资源配置,时间均为 time.ParseDuration 格式字符串,空表示使用 go-redis 默认值
+type RedisConfig struct {
+	Addr            string `json:"addr"`
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	DB              int    `json:"db"`
+	PoolSize        int    `json:"poolSize"`     // 最大连接数,0 表示 go-redis 默认值(10*cpu)
+	MinIdleConns    int    `json:"minIdleConns"` // 最小空闲连接数
+	ConnMaxIdleTime string `json:"connMaxIdleTime"`
+	DialTimeout     string `json:"dialTimeout"`
+	ReadTimeout     string `json:"readTimeout"`
+	WriteTimeout    string `json:"writeTimeout"`
+	PingOnOpen      bool   `json:"pingOnOpen"` // 创建后立即 ping,使错误的地址在创建资源时就暴露
+}
+
+// Options 转换为 go-redis 配置
+func (cfg RedisConfig) Options() (opt *redis.Options, err error) {
+	opt = &redis.Options{
+		Addr:         cfg.Addr,
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		PoolSize:     cfg.PoolSize,
+		MinIdleConns: cfg.MinIdleConns,
+	}
+	durations := []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"connMaxIdleTime", cfg.ConnMaxIdleTime, &opt.ConnMaxIdleTime},
+		{"dialTimeout", cfg.DialTimeout, &opt.DialTimeout},
+		{"readTimeout", cfg.ReadTimeout, &opt.ReadTimeout},
+		{"writeTimeout", cfg.WriteTimeout, &opt.WriteTimeout},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		*d.dst, err = time.ParseDuration(d.value)
+		if err != nil {
+			err = errors.WithMessagef(err, "%s:%s", d.name, d.value)
+			return nil, err
+		}
+	}
+	return opt, nil
+}
+
+// Do 执行单条命令,key 不存在(redis.Nil)时返回 nil,nil
+func Do(ctx context.Context, client redis.UniversalClient, args ...any) (reply any, err error) {
+	logInfo := &LogInfoRedis{Context: ctx}
+	defer func() {
+		logInfo.Reply = reply
+		logInfo.Err = err
+		duration := float64(logInfo.EndAt.Sub(logInfo.BeginAt).Nanoseconds()) / 1e6
+		logInfo.Duration = fmt.Sprintf("%.3fms", duration)
+		logchan.SendLogInfo(logInfo)
+	}()
+	if len(args) == 0 {
+		err = errors.New("redis command required")
+		return nil, err
+	}
+	logInfo.Cmd = fmt.Sprint(args[0])
+	logInfo.Args = args[1:]
+	logInfo.BeginAt = time.Now().Local()
+	reply, err = client.Do(ctx, args...).Result()
+	logInfo.EndAt = time.Now().Local()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		err = errors.WithMessagef(err, "redis:%s", logInfo.Cmd)
+		return nil, err
+	}
+	return reply, nil
+}
+
+// ParseCommands 解析模板渲染的命令文本,每行一条命令,参数以空白分隔,含空白的参数使用单引号或双引号包裹(双引号内支持 \" \\ \n \t 转义),空行及 # 开头的行忽略
+func ParseCommands(text string) (commands [][]any, err error) {
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields, err := splitCommandLine(line)
+		if err != nil {
+			return nil, err
+		}
+		command := make([]any, 0, len(fields))
+		for _, f := range fields {
+			command = append(command, f)
+		}
+		commands = append(commands, command)
+	}
+	return commands, nil
+}
+
+func splitCommandLine(line string) (fields []string, err error) {
+	var field strings.Builder
+	inField := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			switch r {
+			case 'n':
+				r = '\n'
+			case 't':
+				r = '\t'
+			}
+			field.WriteRune(r)
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+				continue
+			}
+			field.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 || escaped {
+		err = errors.Errorf("unclosed quote in command:%s", line)
+		return nil, err
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
@@ -0,0 +1,239 @@
+package tengoredis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/d5/tengo/v2"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/suifengpiao14/tengolib/tengocontext"
+	"github.com/suifengpiao14/tengolib/tengosource"
+)
+
+// TengoRedis redis 资源,脚本中通过 do(ctx,cmd,args...) 执行单条命令,execOrQueryContext(ctx,text) 执行模板渲染的命令文本
+type TengoRedis struct {
+	tengo.ImmutableMap
+	client *redis.Client
+}
+
+func (tengoRedis *TengoRedis) TypeName() string {
+	return "redis"
+}
+func (tengoRedis *TengoRedis) String() string {
+	return ""
+}
+
+func (tengoRedis *TengoRedis) GetClient() *redis.Client {
+	return tengoRedis.client
+}
+
+// Close 关闭连接池
+func (tengoRedis *TengoRedis) Close() (err error) {
+	return tengoRedis.client.Close()
+}
+
+// Health ping redis
+func (tengoRedis *TengoRedis) Health(ctx context.Context) (err error) {
+	err = tengoRedis.client.Ping(ctx).Err()
+	if err != nil {
+		err = errors.WithMessage(err, "ping redis")
+		return err
+	}
+	return nil
+}
+
+// init 导入本包即注册 REDIS 资源类型
+func init() {
+	tengosource.RegisterProviderFactory(tengosource.PROVIDER_REDIS, func(config string) (tengosource.Provider, error) {
+		return NewTengoRedis(config)
+	})
+}
+
+// NewTengoRedis config 为 RedisConfig 的 json 格式
+func NewTengoRedis(config string) (tengoRedis *TengoRedis, err error) {
+	cfg := RedisConfig{}
+	err = json.Unmarshal([]byte(config), &cfg)
+	if err != nil {
+		err = errors.WithMessagef(err, "redis config:%s", config)
+		return nil, err
+	}
+	opt, err := cfg.Options()
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opt)
+	if cfg.PingOnOpen {
+		err = client.Ping(context.Background()).Err()
+		if err != nil {
+			client.Close()
+			err = errors.WithMessagef(err, "ping:%s", cfg.Addr)
+			return nil, err
+		}
+	}
+	tengoRedis = &TengoRedis{
+		ImmutableMap: tengo.ImmutableMap{
+			Value: make(map[string]tengo.Object),
+		},
+		client: client,
+	}
+	//注入tengo 脚本方法
+	methods := map[string]tengo.CallableFunc{
+		"do":                 tengoRedis.TengoDo,
+		"execOrQueryContext": tengoRedis.TengoExecOrQueryContext,
+	}
+	for key, method := range methods {
+		tengoRedis.Value[key] = &tengo.UserFunction{
+			Name:  key,
+			Value: method,
+		}
+	}
+	return tengoRedis, nil
+}
+
+// Do 执行单条命令
+func (tengoRedis *TengoRedis) Do(ctx context.Context, args ...any) (reply any, err error) {
+	return Do(ctx, tengoRedis.client, args...)
+}
+
+// Exec 依次执行命令文本中的每条命令,任一命令失败即返回
+func (tengoRedis *TengoRedis) Exec(ctx context.Context, text string) (replies []any, err error) {
+	commands, err := ParseCommands(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(commands) == 0 {
+		err = errors.Errorf("no redis command in:%s", text)
+		return nil, err
+	}
+	replies = make([]any, 0, len(commands))
+	for _, command := range commands {
+		reply, err := tengoRedis.Do(ctx, command...)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+// ExecOrQueryContext 与 TengoDB 签名一致,单条命令返回其回复的 json 格式,多条命令返回回复数组的 json 格式
+func (tengoRedis *TengoRedis) ExecOrQueryContext(ctx context.Context, text string) (out string, err error) {
+	replies, err := tengoRedis.Exec(ctx, text)
+	if err != nil {
+		return "", err
+	}
+	var reply any = replies
+	if len(replies) == 1 {
+		reply = replies[0]
+	}
+	b, err := json.Marshal(normalizeReply(reply))
+	if err != nil {
+		err = errors.WithMessagef(err, "redis reply:%v", reply)
+		return "", err
+	}
+	return string(b), nil
+}
+
+// TengoDo do(ctx,cmd,args...) 数组参数展开为多个参数,如 do(ctx,"mset",["a",1,"b",2])
+func (tengoRedis *TengoRedis) TengoDo(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) < 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	ctx, err := toContext(args[0])
+	if err != nil {
+		return nil, err
+	}
+	cmd, ok := tengo.ToString(args[1])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "cmd",
+			Expected: "string",
+			Found:    args[1].TypeName(),
+		}
+	}
+	command := []any{cmd}
+	for _, arg := range args[2:] {
+		switch o := arg.(type) {
+		case *tengo.Array:
+			for _, item := range o.Value {
+				command = append(command, tengo.ToInterface(item))
+			}
+		case *tengo.ImmutableArray:
+			for _, item := range o.Value {
+				command = append(command, tengo.ToInterface(item))
+			}
+		default:
+			command = append(command, tengo.ToInterface(arg))
+		}
+	}
+	reply, err := tengoRedis.Do(ctx, command...)
+	if err != nil {
+		return nil, err
+	}
+	return ReplyToTengo(reply)
+}
+
+// TengoExecOrQueryContext 单条命令返回其回复,多条命令返回回复数组
+func (tengoRedis *TengoRedis) TengoExecOrQueryContext(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	ctx, err := toContext(args[0])
+	if err != nil {
+		return nil, err
+	}
+	text, ok := tengo.ToString(args[1])
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "text",
+			Expected: "string",
+			Found:    args[1].TypeName(),
+		}
+	}
+	replies, err := tengoRedis.Exec(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	if len(replies) == 1 {
+		return ReplyToTengo(replies[0])
+	}
+	return ReplyToTengo(replies)
+}
+
+func toContext(obj tengo.Object) (ctx context.Context, err error) {
+	ctxObj, ok := obj.(*tengocontext.TengoContext)
+	if !ok {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "context",
+			Expected: "context.Context",
+			Found:    obj.TypeName(),
+		}
+	}
+	return ctxObj.Context, nil
+}
+
+// ReplyToTengo 将回复转换为 tengo 对象:nil 为 undefined,字符串、整数、浮点数、布尔为对应类型,数组为 array,RESP3 map 为 map(键转为字符串)
+func ReplyToTengo(reply any) (ret tengo.Object, err error) {
+	return tengo.FromInterface(normalizeReply(reply))
+}
+
+// normalizeReply 将 map[interface{}]interface{} 转换为 map[string]interface{},便于转换为 tengo 对象及 json
+func normalizeReply(reply any) any {
+	switch v := reply.(type) {
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = normalizeReply(item)
+		}
+		return out
+	case map[any]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[fmt.Sprint(k)] = normalizeReply(item)
+		}
+		return out
+	}
+	return reply
+}
@@ -0,0 +1,76 @@
+package tengoredis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/d5/tengo/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/tengolib/tengocontext"
+	"github.com/suifengpiao14/tengolib/tengosource"
+)
+
+func TestParseCommands(t *testing.T) {
+	commands, err := ParseCommands("set user:1 \"tom \\\"cat\\\"\"\r\n\n# comment\n hset h 'a b' 1 ")
+	require.NoError(t, err)
+	require.Equal(t, [][]any{
+		{"set", "user:1", `tom "cat"`},
+		{"hset", "h", "a b", "1"},
+	}, commands)
+	_, err = ParseCommands(`set a "b`)
+	require.Error(t, err)
+}
+
+func TestTengoRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	tengoRedis, err := NewTengoRedis(`{"addr":"` + server.Addr() + `","poolSize":2,"readTimeout":"1s","pingOnOpen":true}`)
+	require.NoError(t, err)
+	defer tengoRedis.Close()
+
+	out, err := tengoRedis.ExecOrQueryContext(context.Background(), "set name tom\nrpush ids 1 2 3")
+	require.NoError(t, err)
+	require.Equal(t, `["OK",3]`, out)
+
+	script := tengo.NewScript([]byte(`
+	name:=redis.do(ctx,"get","name")
+	missing:=redis.do(ctx,"get","missing")
+	ids:=redis.execOrQueryContext(ctx,"lrange ids 0 -1")
+	count:=redis.do(ctx,"incrby","counter",5)
+	redis.do(ctx,"mset",["a",1,"b",2])
+	`))
+	require.NoError(t, script.Add("redis", tengoRedis))
+	require.NoError(t, script.Add("ctx", tengocontext.NewTengoContext(context.Background())))
+	c, err := script.Run()
+	require.NoError(t, err)
+	require.Equal(t, "tom", c.Get("name").String())
+	require.True(t, c.Get("missing").IsUndefined())
+	require.Equal(t, []interface{}{"1", "2", "3"}, c.Get("ids").Array())
+	require.Equal(t, 5, c.Get("count").Int())
+	b, err := server.Get("b")
+	require.NoError(t, err)
+	require.Equal(t, "2", b)
+
+	_, err = tengoRedis.Do(context.Background(), "get", "ids")
+	require.Error(t, err)
+	_, err = NewTengoRedis(`{"addr":"` + server.Addr() + `","dialTimeout":"1x"}`)
+	require.Error(t, err)
+}
+
+func TestTengoRedisHealth(t *testing.T) {
+	server := miniredis.RunT(t)
+	tengoRedis, err := NewTengoRedis(`{"addr":"` + server.Addr() + `"}`)
+	require.NoError(t, err)
+	defer tengoRedis.Close()
+	require.NoError(t, tengoRedis.Health(context.Background()))
+
+	// 导入本包即注册 REDIS 资源类型
+	source, err := tengosource.MakeSource("cache", tengosource.PROVIDER_REDIS, `{"addr":"`+server.Addr()+`"}`)
+	require.NoError(t, err)
+	pool := tengosource.NewSourcePool()
+	require.NoError(t, pool.RegisterSource(source))
+	require.Empty(t, pool.Health(context.Background()))
+	require.NoError(t, pool.Close())
+	server.Close()
+	require.Error(t, tengoRedis.Health(context.Background()))
+}
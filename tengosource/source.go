@@ -1,6 +1,7 @@
 package tengosource
 
 import (
+	"context"
 	"sync"
 
 	"github.com/d5/tengo/v2"
@@ -8,6 +9,7 @@ import (
 	"github.com/suifengpiao14/tengolib/tengodb"
 )
 
+// 资源类型,SQL、SQL_MEMORY 默认注册;其余类型由对应的包在 init 中注册,使用前需导入,如 import _ "github.com/suifengpiao14/tengolib/tengoredis"
 const (
 	PROVIDER_SQL_MEMORY = "SQL_MEMORY"
 	PROVIDER_SQL        = "SQL"
@@ -65,42 +67,90 @@ func NewSourcePool() (p *SourcePool) {
 	return p
 }
 
+// Provider 资源提供者,同时作为 tengo 对象注入脚本
+type Provider interface {
+	tengo.Object
+	ExecOrQueryContext(ctx context.Context, s string) (out string, err error)
+	Close() (err error)
+	Health(ctx context.Context) (err error)
+}
+
+// ProviderFactory 根据资源配置创建提供者
+type ProviderFactory func(config string) (provider Provider, err error)
+
+var (
+	providerFactories = map[string]ProviderFactory{
+		PROVIDER_SQL: func(config string) (Provider, error) {
+			return tengodb.NewTengoDB(config)
+		},
+		PROVIDER_SQL_MEMORY: func(config string) (Provider, error) {
+			return tengodb.NewTengoMemoryDB(config)
+		},
+	}
+	providerFactoryLock sync.RWMutex
+)
+
+// RegisterProviderFactory 注册资源类型对应的提供者工厂,类型已存在时覆盖(如将 SQL 替换为内存实现)
+func RegisterProviderFactory(typ string, factory ProviderFactory) {
+	providerFactoryLock.Lock()
+	defer providerFactoryLock.Unlock()
+	providerFactories[typ] = factory
+}
+
+// GetProviderFactory 获取资源类型对应的提供者工厂
+func GetProviderFactory(typ string) (factory ProviderFactory, ok bool) {
+	providerFactoryLock.RLock()
+	defer providerFactoryLock.RUnlock()
+	factory, ok = providerFactories[typ]
+	return factory, ok
+}
+
 type Source struct {
 	Identifer string
 	Type      string
 	Config    string
-	provider  tengo.Object
+	provider  Provider
 }
 
 //SetProvider 方便外部替换修改(如替换成内存实现提供者)
-func (s *Source) SetProvider(provider tengo.Object) {
+func (s *Source) SetProvider(provider Provider) {
 	s.provider = provider
 }
 
-//MakeSource 创建常规资源,方便外部统一调用
+//MakeSource 创建常规资源,方便外部统一调用,提供者由 Type 对应的 ProviderFactory 创建
 func MakeSource(identifer string, typ string, config string) (s Source, err error) {
 	s = Source{
 		Identifer: identifer,
 		Type:      typ,
 		Config:    config,
 	}
-	var provider tengo.Object
-	switch s.Type {
-	case PROVIDER_SQL:
-		provider, err = tengodb.NewTengoDB(s.Config)
-		if err != nil {
-			return s, err
-		}
-		//todo curl , bin 提供者实现
+	factory, ok := GetProviderFactory(s.Type)
+	if !ok {
+		err = errors.Errorf("unsupported source type:%s", s.Type)
+		return s, err
+	}
+	provider, err := factory(s.Config)
+	if err != nil {
+		return s, err
 	}
 	s.provider = provider
 	return s, nil
 }
 
+// RegisterSource 注册资源,标识已存在时替换并关闭原提供者(与新资源为同一提供者时不关闭)
 func (p *SourcePool) RegisterSource(s Source) (err error) {
 	p.lock.Lock()
-	defer p.lock.Unlock()
+	old, ok := p.sourceMap[s.Identifer]
 	p.sourceMap[s.Identifer] = s
+	p.lock.Unlock()
+	if !ok || old.provider == nil || old.provider == s.provider {
+		return nil
+	}
+	err = old.provider.Close()
+	if err != nil {
+		err = errors.WithMessagef(err, "close replaced source:%s", old.Identifer)
+		return err
+	}
 	return nil
 }
 
@@ -118,7 +168,7 @@ func (p *SourcePool) AddTemplateIdentiferRelation(templateIdentifer string, sour
 	return nil
 }
 
-func (p *SourcePool) GetProviderBySourceIdentifer(sourceIdentifer string) (sourceProvider tengo.Object, err error) {
+func (p *SourcePool) GetProviderBySourceIdentifer(sourceIdentifer string) (sourceProvider Provider, err error) {
 	source, ok := p.sourceMap[sourceIdentifer]
 	if !ok {
 		err = errors.Errorf("not found source by source identifier: %s", sourceIdentifer)
@@ -127,7 +177,7 @@ func (p *SourcePool) GetProviderBySourceIdentifer(sourceIdentifer string) (sourc
 	sourceProvider = source.provider
 	return sourceProvider, nil
 }
-func (p *SourcePool) GetProviderByTemplateIdentifer(templateIdentifier string) (sourceProvider tengo.Object, err error) {
+func (p *SourcePool) GetProviderByTemplateIdentifer(templateIdentifier string) (sourceProvider Provider, err error) {
 	sourceIdentifer, err := p.IdentiferRelationCollection.GetSourceIdentiferByTemplateIdentifer(templateIdentifier)
 	if err != nil {
 		return nil, err
@@ -182,3 +232,40 @@ func (p *SourcePool) TengoGetProviderByTemplateIdentifer(args ...tengo.Object) (
 	}
 	return sourceProvider, nil
 }
+
+// Close 关闭并移除所有资源的提供者,返回第一个关闭错误;重复调用不会再次关闭(共享的连接池按引用计数释放)
+func (p *SourcePool) Close() (err error) {
+	p.lock.Lock()
+	sourceMap := p.sourceMap
+	p.sourceMap = make(map[string]Source)
+	p.lock.Unlock()
+	for _, source := range sourceMap {
+		if source.provider == nil {
+			continue
+		}
+		if closeErr := source.provider.Close(); closeErr != nil && err == nil {
+			err = errors.WithMessagef(closeErr, "close source:%s", source.Identifer)
+		}
+	}
+	return err
+}
+
+// Health 检查所有资源,返回检查失败的资源标识及错误;检查期间不持有锁,避免慢检查阻塞资源获取
+func (p *SourcePool) Health(ctx context.Context) (errs map[string]error) {
+	p.lock.Lock()
+	providers := make(map[string]Provider, len(p.sourceMap))
+	for identifer, source := range p.sourceMap {
+		if source.provider == nil {
+			continue
+		}
+		providers[identifer] = source.provider
+	}
+	p.lock.Unlock()
+	errs = make(map[string]error)
+	for identifer, provider := range providers {
+		if err := provider.Health(ctx); err != nil {
+			errs[identifer] = err
+		}
+	}
+	return errs
+}
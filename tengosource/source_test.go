@@ -0,0 +1,120 @@
+package tengosource
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/d5/tengo/v2"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/tengolib/tengodb"
+)
+
+type echoProvider struct {
+	tengo.ObjectImpl
+	config string
+	closed bool
+}
+
+func (p *echoProvider) ExecOrQueryContext(ctx context.Context, s string) (out string, err error) {
+	return p.config + ":" + s, nil
+}
+
+func (p *echoProvider) Close() (err error) {
+	p.closed = true
+	return nil
+}
+
+func (p *echoProvider) Health(ctx context.Context) (err error) {
+	return errors.New("unhealthy")
+}
+
+func TestMakeSource(t *testing.T) {
+	s, err := MakeSource("memory", PROVIDER_SQL_MEMORY, "")
+	require.NoError(t, err)
+	require.NotNil(t, s.provider)
+
+	_, err = MakeSource("echo", "ECHO", "cfg")
+	require.Error(t, err)
+
+	RegisterProviderFactory("ECHO", func(config string) (Provider, error) {
+		return &echoProvider{config: config}, nil
+	})
+	s, err = MakeSource("echo", "ECHO", "cfg")
+	require.NoError(t, err)
+	out, err := s.provider.ExecOrQueryContext(context.Background(), "ping")
+	require.NoError(t, err)
+	require.Equal(t, "cfg:ping", out)
+
+	pool := NewSourcePool()
+	require.NoError(t, pool.RegisterSource(s))
+	provider, err := pool.GetProviderBySourceIdentifer("echo")
+	require.NoError(t, err)
+	errs := pool.Health(context.Background())
+	require.Len(t, errs, 1)
+	require.Error(t, errs["echo"])
+	require.NoError(t, pool.Close())
+	require.True(t, provider.(*echoProvider).closed)
+	_, err = pool.GetProviderBySourceIdentifer("echo")
+	require.Error(t, err)
+
+	replaced := &echoProvider{}
+	s.SetProvider(replaced)
+	require.NoError(t, pool.RegisterSource(s))
+	require.NoError(t, pool.RegisterSource(s)) // 同一提供者不关闭
+	require.False(t, replaced.closed)
+	s.SetProvider(&echoProvider{})
+	require.NoError(t, pool.RegisterSource(s))
+	require.True(t, replaced.closed)
+}
+
+func TestSourcesShareDBConfig(t *testing.T) {
+	config := `{"driver":"sqlite3","dsn":"` + filepath.Join(t.TempDir(), "share.db") + `"}`
+	s1, err := MakeSource("db1", PROVIDER_SQL, config)
+	require.NoError(t, err)
+	s2, err := MakeSource("db2", PROVIDER_SQL, config)
+	require.NoError(t, err)
+	require.Same(t, s1.provider, s2.provider)
+	pool1, pool2 := NewSourcePool(), NewSourcePool()
+	require.NoError(t, pool1.RegisterSource(s1))
+	require.NoError(t, pool2.RegisterSource(s2))
+
+	ctx := context.Background()
+	require.NoError(t, pool1.Close())
+	require.NoError(t, pool1.Close()) // 重复关闭不再释放引用
+	require.Empty(t, pool2.Health(ctx))
+	_, err = s2.provider.ExecOrQueryContext(ctx, "select 1")
+	require.NoError(t, err)
+
+	require.NoError(t, pool2.Close())
+	require.Error(t, s2.provider.(*tengodb.TengoDB).GetDB().PingContext(ctx))
+}
+
+// reentrantProvider 检查时向资源池注册资源
+type reentrantProvider struct {
+	echoProvider
+	pool *SourcePool
+}
+
+func (p *reentrantProvider) Health(ctx context.Context) (err error) {
+	return p.pool.RegisterSource(Source{Identifer: "registeredInHealth"})
+}
+
+func TestSourcePoolHealthUnlocked(t *testing.T) {
+	pool := NewSourcePool()
+	require.NoError(t, pool.RegisterSource(Source{
+		Identifer: "reentrant",
+		provider:  &reentrantProvider{pool: pool},
+	}))
+	done := make(chan map[string]error)
+	go func() { done <- pool.Health(context.Background()) }()
+	select {
+	case errs := <-done:
+		require.Empty(t, errs)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Health holds the pool lock while checking")
+	}
+}
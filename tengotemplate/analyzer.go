@@ -0,0 +1,190 @@
+package tengotemplate
+
+import (
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+// TemplatefuncVolumeKeys 模板函数写入 volume 的键,外部新增写 volume 的模板函数时在此登记,* 表示动态序号
+var TemplatefuncVolumeKeys = map[string][]string{
+	"zeroTime":      {"ZeroTime"},
+	"currentTime":   {"CurrentTime"},
+	"permanentTime": {"PermanentTime"},
+	"in":            {"in_*", IN_INDEX},
+}
+
+// TemplateAnalysis 模板静态分析结果,包含被调用模板中的引用
+type TemplateAnalysis struct {
+	Name      string   `json:"name"`
+	Fields    []string `json:"fields"`    // 读取的入参字段及 sql 文本中的命名占位符(:name),嵌套字段以 . 连接,如 user.address.city
+	Writes    []string `json:"writes"`    // 模板函数写入 volume 的键
+	Funcs     []string `json:"funcs"`     // 调用的函数
+	Templates []string `json:"templates"` // 调用的模板
+}
+
+// Analyze 分析模板读取的字段、模板函数写入的键及调用的模板;range 内的字段相对于元素,不计入,文本中的命名占位符始终从入参读取,计入
+func (t *TengoTemplate) Analyze(tplName string) (analysis *TemplateAnalysis, err error) {
+	tpl := t.GetTemplate()
+	if tpl.Lookup(tplName) == nil {
+		err = errors.Errorf("template %s not found", tplName)
+		return nil, err
+	}
+	a := &analyzer{
+		lookup:    func(name string) *parse.Tree { return treeOf(tpl, name) },
+		fields:    make(map[string]bool),
+		writes:    make(map[string]bool),
+		funcs:     make(map[string]bool),
+		templates: make(map[string]bool),
+		visiting:  make(map[string]bool),
+	}
+	root := ""
+	a.walkTemplate(tplName, &root)
+	analysis = &TemplateAnalysis{
+		Name:      tplName,
+		Fields:    sortedKeys(a.fields),
+		Writes:    sortedKeys(a.writes),
+		Funcs:     sortedKeys(a.funcs),
+		Templates: sortedKeys(a.templates),
+	}
+	return analysis, nil
+}
+
+type analyzer struct {
+	lookup    func(name string) *parse.Tree
+	fields    map[string]bool
+	writes    map[string]bool
+	funcs     map[string]bool
+	templates map[string]bool
+	visiting  map[string]bool
+}
+
+// walkTemplate dot 为当前 . 相对入参的路径,nil 表示无法确定(如 range 元素)
+func (a *analyzer) walkTemplate(name string, dot *string) {
+	tree := a.lookup(name)
+	if tree == nil || tree.Root == nil {
+		return
+	}
+	key := name
+	if dot != nil {
+		key = name + "@" + *dot
+	}
+	if a.visiting[key] { // 递归模板
+		return
+	}
+	a.visiting[key] = true
+	defer delete(a.visiting, key)
+	a.walk(tree.Root, dot)
+}
+
+func (a *analyzer) walk(node parse.Node, dot *string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			a.walk(child, dot)
+		}
+	case *parse.TextNode:
+		for _, name := range NamedPlaceholders(string(n.Text)) {
+			a.fields[name] = true
+		}
+	case *parse.ActionNode:
+		a.walk(n.Pipe, dot)
+	case *parse.IfNode:
+		a.walkBranch(&n.BranchNode, dot, dot)
+	case *parse.WithNode:
+		a.walkBranch(&n.BranchNode, dot, a.pipePath(n.Pipe, dot))
+	case *parse.RangeNode:
+		a.walkBranch(&n.BranchNode, dot, nil)
+	case *parse.TemplateNode:
+		a.templates[n.Name] = true
+		var childDot *string
+		if n.Pipe != nil {
+			a.walk(n.Pipe, dot)
+			childDot = a.pipePath(n.Pipe, dot)
+		}
+		a.walkTemplate(n.Name, childDot)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			a.walk(cmd, dot)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			a.walk(arg, dot)
+		}
+	case *parse.IdentifierNode:
+		a.funcs[n.Ident] = true
+		for _, key := range TemplatefuncVolumeKeys[n.Ident] {
+			a.writes[key] = true
+		}
+	case *parse.FieldNode:
+		a.addField(dot, n.Ident)
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			root := ""
+			a.addField(&root, n.Ident[1:])
+		}
+	case *parse.ChainNode:
+		a.walk(n.Node, dot)
+	}
+}
+
+func (a *analyzer) walkBranch(n *parse.BranchNode, dot *string, listDot *string) {
+	a.walk(n.Pipe, dot)
+	a.walk(n.List, listDot)
+	a.walk(n.ElseList, dot)
+}
+
+func (a *analyzer) addField(dot *string, ident []string) {
+	if dot == nil || len(ident) == 0 {
+		return
+	}
+	field := strings.Join(ident, ".")
+	if *dot != "" {
+		field = *dot + "." + field
+	}
+	a.fields[field] = true
+}
+
+// pipePath 管道仅为 . 或 .field 时返回对应路径,否则返回 nil
+func (a *analyzer) pipePath(pipe *parse.PipeNode, dot *string) *string {
+	if dot == nil || pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return nil
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		p := strings.Join(arg.Ident, ".")
+		if *dot != "" {
+			p = *dot + "." + p
+		}
+		return &p
+	}
+	return nil
+}
+
+func treeOf(tpl *template.Template, name string) *parse.Tree {
+	t := tpl.Lookup(name)
+	if t == nil {
+		return nil
+	}
+	return t.Tree
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+package tengotemplate
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+const (
+	DRIVER_MYSQL    = "mysql"
+	DRIVER_POSTGRES = "postgres"
+	DRIVER_SQLITE   = "sqlite3"
+)
+
+// Dialect 不同数据库在绑定参数占位符、字符串字面量转义上的差异
+type Dialect struct {
+	BindType int                   // 占位符类型,取值同 sqlx.BindType(QUESTION:?,DOLLAR:$1)
+	Quote    func(s string) string // 内联字符串值时的转义规则
+}
+
+// QuoteMySQL mysql 使用反斜杠转义单引号(与 gorm ExplainSQL 保持一致)
+func QuoteMySQL(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `\'`) + `'`
+}
+
+// QuoteStandard 标准sql(postgres,sqlite)使用两个单引号转义单引号
+func QuoteStandard(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
+
+var dialectMap = map[string]Dialect{
+	DRIVER_MYSQL:    {BindType: sqlx.QUESTION, Quote: QuoteMySQL},
+	DRIVER_POSTGRES: {BindType: sqlx.DOLLAR, Quote: QuoteStandard},
+	"pgx":           {BindType: sqlx.DOLLAR, Quote: QuoteStandard},
+	DRIVER_SQLITE:   {BindType: sqlx.QUESTION, Quote: QuoteStandard},
+	"sqlite":        {BindType: sqlx.QUESTION, Quote: QuoteStandard},
+}
+var dialectLock sync.RWMutex
+
+// RegisterDialect 注册驱动对应的方言,方便外部扩展其它驱动
+func RegisterDialect(driverName string, dialect Dialect) {
+	dialectLock.Lock()
+	defer dialectLock.Unlock()
+	dialectMap[driverName] = dialect
+}
+
+// GetDialect 获取驱动对应的方言,未注册时按 sqlx 记录的占位符类型及标准sql转义处理
+func GetDialect(driverName string) (dialect Dialect) {
+	dialectLock.RLock()
+	defer dialectLock.RUnlock()
+	dialect, ok := dialectMap[driverName]
+	if !ok {
+		dialect = Dialect{BindType: sqlx.BindType(driverName), Quote: QuoteStandard}
+	}
+	return dialect
+}
+
+// Rebind 将 ? 占位符转换为方言对应的占位符
+func (d Dialect) Rebind(statement string) string {
+	return sqlx.Rebind(d.BindType, statement)
+}
+
+// Explain 将绑定参数内联到 ? 占位符语句中,字符串按方言转义,其余类型沿用 gorm ExplainSQL 的格式
+func (d Dialect) Explain(statement string, arguments ...interface{}) (sql string) {
+	var w strings.Builder
+	var quote rune
+	idx := 0
+	for _, c := range statement {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?' && idx < len(arguments):
+			w.WriteString(d.literal(arguments[idx]))
+			idx++
+			continue
+		}
+		w.WriteRune(c)
+	}
+	return w.String()
+}
+
+func (d Dialect) literal(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return d.Quote(v)
+	case []byte:
+		return d.Quote(string(v))
+	}
+	return gormLogger.ExplainSQL("?", nil, `'`, v)
+}
@@ -0,0 +1,25 @@
+package tengotemplate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestToSQLByDriver(t *testing.T) {
+	named := "select * from user where name=:name and id=:id"
+	data := map[string]interface{}{"name": "o'neil", "id": 1}
+
+	statement, arguments, err := ToNamedSQLByDriver(DRIVER_POSTGRES, named, data)
+	require.NoError(t, err)
+	require.Equal(t, "select * from user where name=$1 and id=$2", statement)
+	require.Equal(t, []interface{}{"o'neil", 1}, arguments)
+
+	sql, err := ToSQLByDriver(DRIVER_POSTGRES, named, data)
+	require.NoError(t, err)
+	require.Equal(t, "select * from user where name='o''neil' and id=1", sql)
+
+	sql, err = ToSQLByDriver(DRIVER_MYSQL, named, data)
+	require.NoError(t, err)
+	require.Equal(t, `select * from user where name='o\'neil' and id=1`, sql)
+}
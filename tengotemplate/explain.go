@@ -0,0 +1,143 @@
+package tengotemplate
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/d5/tengo/v2"
+)
+
+const (
+	SQL_TYPE_SELECT = "SELECT"
+	SQL_TYPE_OTHER  = "OTHER"
+)
+
+// SQLType 判断 sql  属于那种类型
+func SQLType(sqls string) string {
+	sqlArr := strings.Split(sqls, EOF)
+	selectLen := len(SQL_TYPE_SELECT)
+	for _, sql := range sqlArr {
+		if len(sql) < selectLen {
+			continue
+		}
+		pre := sql[:selectLen]
+		if strings.ToUpper(pre) == SQL_TYPE_SELECT {
+			return SQL_TYPE_SELECT
+		}
+	}
+	return SQL_TYPE_OTHER
+}
+
+// TemplateExplain 模板到sql各阶段的结果,用于调试
+type TemplateExplain struct {
+	Name      string                 `json:"name"`
+	Named     string                 `json:"named"`     // 模板渲染出的命名sql
+	Data      map[string]interface{} `json:"data"`      // 渲染后的 volume(含模板函数写入的值)
+	Statement string                 `json:"statement"` // 模板驱动对应占位符的语句
+	Args      []interface{}          `json:"args"`      // 绑定参数
+	SQL       string                 `json:"sql"`       // 按模板驱动的方言内联参数后的sql(同 ToSQLByDriver)
+	SQLType   string                 `json:"sqlType"`
+	Missing   []string               `json:"missing"` // 没有数据的命名占位符,不为空时 Statement、Args、SQL 为空
+}
+
+// Explain 执行模板但不执行sql,返回各阶段的结果;模板执行出错时返回错误,占位符缺少数据时记录在 Missing 中
+func (t *TengoTemplate) Explain(tplName string, volume VolumeInterface) (explain *TemplateExplain, err error) {
+	out, changedVolume, err := t.Exec(tplName, volume)
+	if err != nil {
+		return nil, err
+	}
+	data := changedVolume.ToMap()
+	explain = &TemplateExplain{
+		Name:    tplName,
+		Named:   out,
+		Data:    data,
+		SQLType: SQLType(out),
+		Missing: make([]string, 0),
+	}
+	for _, name := range NamedPlaceholders(out) {
+		if _, ok := data[name]; !ok {
+			explain.Missing = append(explain.Missing, name)
+		}
+	}
+	if len(explain.Missing) > 0 {
+		return explain, nil
+	}
+	driverName := t.DriverName()
+	explain.Statement, explain.Args, err = ToNamedSQLByDriver(driverName, out, data)
+	if err != nil {
+		return nil, err
+	}
+	explain.SQL, err = ToSQLByDriver(driverName, out, data)
+	if err != nil {
+		return nil, err
+	}
+	return explain, nil
+}
+
+// TengoExplain explain(tplName,data) 返回 {name,named,data,statement,args,sql,sqlType,missing}
+func (t *TengoTemplate) TengoExplain(args ...tengo.Object) (ret tengo.Object, err error) {
+	tplName, volume, err := parseTplArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	explain, err := t.Explain(tplName, volume)
+	if err != nil {
+		return nil, err
+	}
+	data, err := tengo.FromInterface(explain.Data)
+	if err != nil {
+		return nil, err
+	}
+	arguments, err := tengo.FromInterface(explain.Args)
+	if err != nil {
+		return nil, err
+	}
+	missing := &tengo.ImmutableArray{Value: make([]tengo.Object, 0, len(explain.Missing))}
+	for _, name := range explain.Missing {
+		missing.Value = append(missing.Value, &tengo.String{Value: name})
+	}
+	ret = &tengo.ImmutableMap{
+		Value: map[string]tengo.Object{
+			"name":      &tengo.String{Value: explain.Name},
+			"named":     &tengo.String{Value: explain.Named},
+			"data":      data,
+			"statement": &tengo.String{Value: explain.Statement},
+			"args":      arguments,
+			"sql":       &tengo.String{Value: explain.SQL},
+			"sqlType":   &tengo.String{Value: explain.SQLType},
+			"missing":   missing,
+		},
+	}
+	return ret, nil
+}
+
+// NamedPlaceholders 按 sqlx 的规则提取命名占位符(:name),:: 为转义的冒号
+func NamedPlaceholders(named string) (names []string) {
+	names = make([]string, 0)
+	seen := make(map[string]bool)
+	for i := 0; i < len(named); i++ {
+		if named[i] != ':' {
+			continue
+		}
+		if i+1 < len(named) && named[i+1] == ':' {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(named) {
+			r, size := utf8.DecodeRuneInString(named[j:])
+			if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.') {
+				break
+			}
+			j += size
+		}
+		name := named[i+1 : j]
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		i = j - 1
+	}
+	return names
+}
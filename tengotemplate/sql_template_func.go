@@ -113,7 +113,10 @@ func In(volume VolumeInterface, data interface{}) (str string, err error) {
 	placeholders := make([]string, 0)
 	inIndexKey := IN_INDEX
 	var inIndex int
-	ok := volume.GetValue(inIndexKey, &inIndex)
+	ok, err := volume.GetValue(inIndexKey, &inIndex)
+	if err != nil {
+		return "", err
+	}
 	if !ok {
 		inIndex = 0
 	}
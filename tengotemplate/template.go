@@ -3,8 +3,13 @@ package tengotemplate
 import (
 	"context"
 	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
 
 	"strings"
+	"sync"
 	"text/template"
 
 	"bytes"
@@ -56,8 +61,9 @@ const (
 
 type TemplateOut struct {
 	tengo.ObjectImpl
-	Out  string                 `json:"out"`
-	Data map[string]interface{} `json:"data"`
+	Out        string                 `json:"out"`
+	Data       map[string]interface{} `json:"data"`
+	DriverName string                 `json:"driverName"` // 内联参数时使用的方言,为空时使用 mysql
 }
 
 func (to *TemplateOut) TypeName() string {
@@ -67,13 +73,50 @@ func (to *TemplateOut) String() string {
 	return to.Out
 }
 
+// IndexGet 暴露 toSQL、named、data、exec 方法,便于脚本中链式调用
+func (to *TemplateOut) IndexGet(index tengo.Object) (value tengo.Object, err error) {
+	name, ok := tengo.ToString(index)
+	if !ok {
+		return nil, tengo.ErrInvalidIndexType
+	}
+	var fn tengo.CallableFunc
+	switch name {
+	case "toSQL":
+		fn = to.ToSQL
+	case "named":
+		fn = to.Named
+	case "data":
+		fn = to.TengoData
+	case "exec":
+		fn = to.Exec
+	default:
+		return tengo.UndefinedValue, nil
+	}
+	value = &tengo.UserFunction{
+		Name:  name,
+		Value: fn,
+	}
+	return value, nil
+}
+
+func (to *TemplateOut) driverName() string {
+	if to.DriverName == "" {
+		return DRIVER_MYSQL
+	}
+	return to.DriverName
+}
+
+// ToSQL toSQL(ctx[,driverName]) 将命名sql与数据整合为可直接执行的sql,字符串按驱动对应的方言转义,未指定驱动时使用模板的驱动
 func (to *TemplateOut) ToSQL(args ...tengo.Object) (sqlObj tengo.Object, err error) {
-	sqlLogInfo := &LogInfoTemplateSQL{}
+	sqlLogInfo := &LogInfoTemplateSQL{
+		Named: to.Out,
+		Data:  to.Data,
+	}
 	defer func() {
 		sqlLogInfo.Err = err
 		logchan.SendLogInfo(sqlLogInfo)
 	}()
-	if len(args) != 0 {
+	if len(args) != 1 && len(args) != 2 {
 		return nil, tengo.ErrWrongNumArguments
 	}
 	ctxObj, ok := args[0].(*tengocontext.TengoContext)
@@ -84,23 +127,163 @@ func (to *TemplateOut) ToSQL(args ...tengo.Object) (sqlObj tengo.Object, err err
 			Found:    args[0].TypeName(),
 		}
 	}
-	sqlStr, err := ToSQL(to.Out, to.Data)
+	driverName := to.driverName()
+	if len(args) == 2 {
+		driverName, ok = tengo.ToString(args[1])
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{
+				Name:     "driverName",
+				Expected: "string",
+				Found:    args[1].TypeName(),
+			}
+		}
+	}
+	sqlLogInfo.Context = ctxObj.Context
+	sqlStr, err := ToSQLByDriver(driverName, to.Out, to.Data)
 	if err != nil {
 		return nil, err
 	}
-	sqlLogInfo.Context = ctxObj.Context
 	sqlObj = &tengo.String{Value: sqlStr}
 	sqlLogInfo.SQL = sqlStr
 	return sqlObj, nil
 }
 
+// Named named() 返回模板生成的命名sql
+func (to *TemplateOut) Named(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 0 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	return &tengo.String{Value: to.Out}, nil
+}
+
+// TengoData data() 返回模板执行后的数据(含模板函数写入的值)
+func (to *TemplateOut) TengoData(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 0 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	return tengo.FromInterface(to.Data)
+}
+
+// driverNamer 资源实现该接口时,内联参数使用资源的驱动方言
+type driverNamer interface {
+	DriverName() string
+}
+
+// Exec exec(ctx,db) 使用资源执行,优先调用 db.execOrQueryNamed(参数绑定),不支持时调用 db.execOrQueryContext 执行 toSQL 的结果(按资源驱动的方言内联)
+func (to *TemplateOut) Exec(args ...tengo.Object) (ret tengo.Object, err error) {
+	if len(args) != 2 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	ctxObj, dbObj := args[0], args[1]
+	if fn := getCallableMember(dbObj, "execOrQueryNamed"); fn != nil {
+		return fn.Call(ctxObj, to)
+	}
+	fn := getCallableMember(dbObj, "execOrQueryContext")
+	if fn == nil {
+		return nil, tengo.ErrInvalidArgumentType{
+			Name:     "db",
+			Expected: "object with execOrQueryNamed or execOrQueryContext",
+			Found:    dbObj.TypeName(),
+		}
+	}
+	driverName := to.driverName()
+	if namer, ok := dbObj.(driverNamer); ok && namer.DriverName() != "" {
+		driverName = namer.DriverName()
+	}
+	sqlObj, err := to.ToSQL(ctxObj, &tengo.String{Value: driverName})
+	if err != nil {
+		return nil, err
+	}
+	return fn.Call(ctxObj, sqlObj)
+}
+
+func getCallableMember(obj tengo.Object, name string) tengo.Object {
+	member, err := obj.IndexGet(&tengo.String{Value: name})
+	if err != nil || member == nil || !member.CanCall() {
+		return nil
+	}
+	return member
+}
+
+// InlineSQL 按模板输出的驱动方言内联参数,同 toSQL(ctx)
+func (to *TemplateOut) InlineSQL() (sql string, err error) {
+	return ToSQLByDriver(to.driverName(), to.Out, to.Data)
+}
+
+// ToNamedSQL 获取 ? 占位符语句及绑定参数
+func (to *TemplateOut) ToNamedSQL() (statement string, arguments []interface{}, err error) {
+	return ToNamedSQL(to.Out, to.Data)
+}
+
 // TemplateFuncMap 外部需要增加模板自定义函数时,在初始化模板前,设置该变量即可
 var TemplateFuncMap = make([]template.FuncMap, 0)
 
 type TengoTemplate struct {
 	tengo.ImmutableMap
-	Template *template.Template
-	tpl      string
+	// Deprecated: Reload 会替换模板集合,直接读取该字段不加锁,请使用 GetTemplate
+	Template   *template.Template
+	tmpl       *template.Template
+	tpl        string
+	sources    []*tplSource // LoadFS、LoadDir、Reload 加载的模板来源,Reload 时只替换对应来源
+	added      []addedTpl   // AddTpl 添加的模板,Reload 时重新解析
+	driverName string
+	lock       sync.RWMutex // 保护 tmpl、tpl、sources、added 的替换(Reload)
+}
+
+// addedTpl 通过 AddTpl 添加的模板源码
+type addedTpl struct {
+	name string
+	s    string
+}
+
+// tplSource 一次 LoadFS 加载的模板,fsys、pattern 相同视为同一来源
+type tplSource struct {
+	fsys      fs.FS
+	pattern   string
+	trees     []*template.Template
+	contents  []string
+	definedIn map[string]string // 模板名称 => 文件名
+}
+
+func (src *tplSource) names() (tplNames []string) {
+	tplNames = make([]string, 0, len(src.trees))
+	for _, tpl := range src.trees {
+		tplNames = append(tplNames, tpl.Name())
+	}
+	return tplNames
+}
+
+// is fs.FS 可比较时按值比较(如 os.DirFS),map、指针等按地址比较(如 fstest.MapFS)
+func (src *tplSource) is(fsys fs.FS, pattern string) bool {
+	if src.pattern != pattern || reflect.TypeOf(src.fsys) != reflect.TypeOf(fsys) {
+		return false
+	}
+	a, b := reflect.ValueOf(src.fsys), reflect.ValueOf(fsys)
+	switch a.Kind() {
+	case reflect.Map, reflect.Ptr, reflect.Slice, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return a.Pointer() == b.Pointer()
+	}
+	if !a.Type().Comparable() {
+		return false
+	}
+	return src.fsys == fsys
+}
+
+// SetDriverName 设置模板输出内联参数(toSQL、explain)时使用的方言
+func (t *TengoTemplate) SetDriverName(driverName string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.driverName = driverName
+}
+
+// DriverName 未设置时为 mysql
+func (t *TengoTemplate) DriverName() string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if t.driverName == "" {
+		return DRIVER_MYSQL
+	}
+	return t.driverName
 }
 
 func NewTemplate() (t *TengoTemplate) {
@@ -113,43 +296,220 @@ func NewTemplate() (t *TengoTemplate) {
 			Value: make(map[string]tengo.Object),
 		},
 		Template: tpl,
+		tmpl:     tpl,
 	}
 	t.Value = map[string]tengo.Object{
 		"exec": &tengo.UserFunction{
 			Value: t.TengoExec,
 		},
+		"explain": &tengo.UserFunction{
+			Value: t.TengoExplain,
+		},
 	}
 	return t
 }
 
 func (t *TengoTemplate) AddTpl(name string, s string) (tplNames []string) {
-	tmpl := t.Template.Lookup(name)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.addTpl(name, s)
+}
+
+func (t *TengoTemplate) addTpl(name string, s string) (tplNames []string) {
+	tmpl := t.tmpl.Lookup(name)
 	if tmpl == nil {
-		tmpl = t.Template.New(name)
+		tmpl = t.tmpl.New(name)
 	}
 	template.Must(tmpl.Parse(s)) // 追加
-	tmp := template.Must(NewTemplate().Template.Parse(s))
+	tmp := template.Must(NewTemplate().tmpl.Parse(s))
 	tplNames = GetTemplateNames(tmp)
 
 	t.tpl = fmt.Sprintf(`%s\n{{define "%s"}}%s{{end}}`, t.tpl, name, s)
+	t.added = append(t.added, addedTpl{name: name, s: s})
 	return tplNames
 }
 
+// LoadDir 加载目录(含子目录)下匹配 pattern 的模板文件,见 LoadFS
+func (t *TengoTemplate) LoadDir(dir string, pattern string) (tplNames []string, err error) {
+	return t.LoadFS(os.DirFS(dir), pattern)
+}
+
+// LoadFS 加载 fsys 中匹配 pattern 的模板文件(如 embed.FS),注册文件中所有 {{define}} 模板;
+// pattern 不含 / 时匹配文件名,否则匹配相对路径;解析错误包含文件名及行号,模板名称重复时返回错误且不注册任何模板
+func (t *TengoTemplate) LoadFS(fsys fs.FS, pattern string) (tplNames []string, err error) {
+	src, err := parseFS(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	err = t.addSource(src)
+	if err != nil {
+		return nil, err
+	}
+	return src.names(), nil
+}
+
+// addSource 注册来源中的模板,与已有模板名称重复时返回错误且不注册任何模板
+func (t *TengoTemplate) addSource(src *tplSource) (err error) {
+	for _, tpl := range src.trees {
+		if t.tmpl.Lookup(tpl.Name()) != nil {
+			return errors.Errorf("template %s in %s already exists", tpl.Name(), src.definedIn[tpl.Name()])
+		}
+	}
+	for _, tpl := range src.trees {
+		_, err = t.tmpl.AddParseTree(tpl.Name(), tpl.Tree)
+		if err != nil {
+			err = errors.WithMessagef(err, "template %s in %s", tpl.Name(), src.definedIn[tpl.Name()])
+			return err
+		}
+	}
+	for _, content := range src.contents {
+		t.tpl = fmt.Sprintf("%s\n%s", t.tpl, content)
+	}
+	t.sources = append(t.sources, src)
+	return nil
+}
+
+// parseFS 解析 fsys 中匹配 pattern 的模板文件,来源内模板名称重复时返回错误
+func parseFS(fsys fs.FS, pattern string) (src *tplSource, err error) {
+	if _, err = path.Match(pattern, ""); err != nil {
+		err = errors.WithMessagef(err, "pattern:%s", pattern)
+		return nil, err
+	}
+	src = &tplSource{
+		fsys:      fsys,
+		pattern:   pattern,
+		trees:     make([]*template.Template, 0),
+		contents:  make([]string, 0),
+		definedIn: make(map[string]string),
+	}
+	err = fs.WalkDir(fsys, ".", func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if !matchTemplateFile(pattern, filename) {
+			return nil
+		}
+		b, err := fs.ReadFile(fsys, filename)
+		if err != nil {
+			return err
+		}
+		content := strings.ReplaceAll(string(b), WINDOW_EOF, EOF)
+		tmp, err := NewTemplate().tmpl.New(filename).Parse(content) // 以文件名命名,解析错误格式为 template: 文件名:行号: 错误
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, tpl := range tmp.Templates() {
+			name := tpl.Name()
+			if name == "" || name == filename || tpl.Tree == nil {
+				continue
+			}
+			if other, ok := src.definedIn[name]; ok {
+				return errors.Errorf("template %s defined in both %s and %s", name, other, filename)
+			}
+			src.definedIn[name] = filename
+			src.trees = append(src.trees, tpl)
+		}
+		src.contents = append(src.contents, content)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// matchTemplateFile pattern 不含 / 时匹配文件名,否则匹配相对路径
+func matchTemplateFile(pattern string, filename string) bool {
+	target := filename
+	if !strings.Contains(pattern, "/") {
+		target = path.Base(filename)
+	}
+	ok, _ := path.Match(pattern, target)
+	return ok
+}
+
+// Reload 重新加载 fsys、pattern 对应来源(之前 LoadFS、LoadDir、Reload 加载)的模板,只替换该来源的模板,未加载过时作为新来源加入;
+// 其他来源及 AddTpl 添加的模板按原顺序重新解析到新集合中,与文件中的同名模板以 AddTpl 为准;加载失败时保留原模板
+func (t *TengoTemplate) Reload(fsys fs.FS, pattern string) (tplNames []string, err error) {
+	src, err := parseFS(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	sources := make([]*tplSource, 0, len(t.sources)+1)
+	replaced := false
+	for _, old := range t.sources {
+		if !replaced && old.is(fsys, pattern) {
+			old, replaced = src, true
+		}
+		sources = append(sources, old)
+	}
+	if !replaced {
+		sources = append(sources, src)
+	}
+	fresh := NewTemplate()
+	for _, s := range sources { // 其他来源使用已解析的模板,不重新读取文件
+		err = fresh.addSource(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, added := range t.added {
+		fresh.addTpl(added.name, added.s)
+	}
+	t.tmpl = fresh.tmpl
+	t.Template = fresh.tmpl
+	t.tpl = fresh.tpl
+	t.sources = fresh.sources
+	t.added = fresh.added
+	return src.names(), nil
+}
+
+// GetTemplate 获取当前模板集合,Reload 后返回新集合
+func (t *TengoTemplate) GetTemplate() *template.Template {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.tmpl
+}
+
 func (t *TengoTemplate) TypeName() string {
 	return "template"
 }
 func (t *TengoTemplate) String() string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
 	return t.tpl
 }
 
 func (t *TengoTemplate) TengoExec(args ...tengo.Object) (tplOut tengo.Object, err error) {
+	tplName, volume, err := parseTplArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	var out string
+	out, changedVolume, err := t.Exec(tplName, volume)
+	if err != nil {
+		return nil, err
+	}
+	tplOut = &TemplateOut{Out: out, Data: changedVolume.ToMap(), DriverName: t.DriverName()}
+	return tplOut, nil
+}
+
+// parseTplArgs 解析 (tplName,data) 形式的脚本参数
+func parseTplArgs(args ...tengo.Object) (tplName string, volume VolumeInterface, err error) {
 	if len(args) != 2 {
-		return nil, tengo.ErrWrongNumArguments
+		return "", nil, tengo.ErrWrongNumArguments
 	}
 
 	tplName, ok := tengo.ToString(args[0])
 	if !ok {
-		return nil, tengo.ErrInvalidArgumentType{
+		return "", nil, tengo.ErrInvalidArgumentType{
 			Name:     "tplName",
 			Expected: "string",
 			Found:    args[0].TypeName(),
@@ -157,31 +517,33 @@ func (t *TengoTemplate) TengoExec(args ...tengo.Object) (tplOut tengo.Object, er
 	}
 	tengoMap, ok := args[1].(*tengo.Map)
 	if !ok {
-		return nil, tengo.ErrInvalidArgumentType{
+		return "", nil, tengo.ErrInvalidArgumentType{
 			Name:     "data",
 			Expected: "map",
 			Found:    args[1].TypeName(),
 		}
 	}
-	volume := &VolumeMap{}
+	volume = &VolumeMap{}
 	for k, v := range tengoMap.Value {
 		volume.SetValue(k, tengo.ToInterface(v))
 	}
-	var out string
-	out, changedVolume, err := t.Exec(tplName, volume)
-	if err != nil {
-		return nil, err
-	}
-	tplOut = &TemplateOut{Out: out, Data: changedVolume.ToMap()}
-	return tplOut, nil
+	return tplName, volume, nil
 }
+
+// Exec 执行模板,volume 实现 VolumeErrInterface 时模板函数写入失败同样返回错误
 func (t *TengoTemplate) Exec(tplName string, volume VolumeInterface) (out string, changedVolume VolumeInterface, err error) {
 	var b bytes.Buffer
-	err = t.Template.ExecuteTemplate(&b, tplName, volume)
+	err = t.GetTemplate().ExecuteTemplate(&b, tplName, templateData(volume))
 	if err != nil {
 		err = errors.WithStack(err)
 		return "", nil, err
 	}
+	if errVolume, ok := volume.(VolumeErrInterface); ok {
+		err = errVolume.Err()
+		if err != nil {
+			return "", nil, err
+		}
+	}
 	out = strings.ReplaceAll(b.String(), WINDOW_EOF, EOF)
 	out = util.TrimSpaces(out)
 	return out, volume, nil
@@ -198,13 +560,45 @@ func GetTemplateNames(t *template.Template) []string {
 	return out
 }
 
+// ToNamedSQL 将命名sql转换为 ? 占位符语句及绑定参数,交由驱动预处理,避免值内联带来的注入、转义问题
+func ToNamedSQL(named string, data map[string]interface{}) (statement string, arguments []interface{}, err error) {
+	statement, arguments, err = sqlx.Named(named, data)
+	if err != nil {
+		err = errors.WithStack(err)
+		return "", nil, err
+	}
+	return statement, arguments, nil
+}
+
 // ToSQL 将字符串、数据整合为sql
 func ToSQL(named string, data map[string]interface{}) (sql string, err error) {
-	statment, arguments, err := sqlx.Named(named, data)
+	statement, arguments, err := ToNamedSQL(named, data)
+	if err != nil {
+		return "", err
+	}
+	sql = gormLogger.ExplainSQL(statement, nil, `'`, arguments...)
+	return sql, nil
+}
+
+// ToNamedSQLByDriver 同 ToNamedSQL,占位符转换为驱动对应的格式(如 postgres 的 $1)
+func ToNamedSQLByDriver(driverName string, named string, data map[string]interface{}) (statement string, arguments []interface{}, err error) {
+	statement, arguments, err = ToNamedSQL(named, data)
+	if err != nil {
+		return "", nil, err
+	}
+	statement = GetDialect(driverName).Rebind(statement)
+	return statement, arguments, nil
+}
+
+// ToSQLByDriver 同 ToSQL,字符串值按驱动对应的方言转义
+func ToSQLByDriver(driverName string, named string, data map[string]interface{}) (sql string, err error) {
+	if driverName == DRIVER_MYSQL {
+		return ToSQL(named, data)
+	}
+	statement, arguments, err := ToNamedSQL(named, data)
 	if err != nil {
-		err = errors.WithStack(err)
 		return "", err
 	}
-	sql = gormLogger.ExplainSQL(statment, nil, `'`, arguments...)
+	sql = GetDialect(driverName).Explain(statement, arguments...)
 	return sql, nil
 }
@@ -0,0 +1,228 @@
+package tengotemplate
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/d5/tengo/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/tengolib/tengocontext"
+)
+
+func TestTemplateOutChain(t *testing.T) {
+	tpl := NewTemplate()
+	tpl.AddTpl("getUser", "select * from user where id=:id")
+	db := &tengo.ImmutableMap{Value: map[string]tengo.Object{
+		"execOrQueryContext": &tengo.UserFunction{
+			Value: func(args ...tengo.Object) (ret tengo.Object, err error) {
+				return &tengo.String{Value: "executed:" + args[1].(*tengo.String).Value}, nil
+			},
+		},
+	}}
+	script := tengo.NewScript([]byte(`
+	out:=tpl.exec("getUser",{id:1})
+	named:=out.named()
+	id:=out.data().id
+	sql:=out.toSQL(ctx)
+	result:=tpl.exec("getUser",{id:2}).exec(ctx,db)
+	`))
+	require.NoError(t, script.Add("tpl", tpl))
+	require.NoError(t, script.Add("db", db))
+	require.NoError(t, script.Add("ctx", &tengocontext.TengoContext{}))
+	c, err := script.Run()
+	require.NoError(t, err)
+	require.Equal(t, "select * from user where id=:id", c.Get("named").String())
+	require.Equal(t, 1, c.Get("id").Int())
+	require.Equal(t, "select * from user where id=1", c.Get("sql").String())
+	require.Equal(t, "executed:select * from user where id=2", c.Get("result").String())
+}
+
+func TestLoadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"user/get.sql.tpl":  {Data: []byte(`{{define "getUser"}}select * from user where id=:id{{end}}`)},
+		"user/list.sql.tpl": {Data: []byte(`{{define "listUser"}}select * from user where id in ({{in . .ids}}){{end}}`)},
+		"readme.md":         {Data: []byte(`{{`)},
+	}
+	tpl := NewTemplate()
+	tplNames, err := tpl.LoadFS(fsys, "*.sql.tpl")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"getUser", "listUser"}, tplNames)
+	out, _, err := tpl.Exec("listUser", &VolumeMap{"ids": []int{1, 2}})
+	require.NoError(t, err)
+	require.Equal(t, "select * from user where id in (:in_1,:in_2)", out)
+
+	_, err = tpl.LoadFS(fstest.MapFS{"dup.sql.tpl": {Data: []byte(`{{define "getUser"}}select 1{{end}}`)}}, "*.sql.tpl")
+	require.ErrorContains(t, err, "getUser")
+
+	_, err = NewTemplate().LoadFS(fstest.MapFS{"bad.sql.tpl": {Data: []byte("select 1\n{{if}}")}}, "*.sql.tpl")
+	require.ErrorContains(t, err, "bad.sql.tpl:2")
+}
+
+func TestTemplateWatcher(t *testing.T) {
+	fsys := fstest.MapFS{
+		"user.sql.tpl": {Data: []byte(`{{define "getUser"}}select * from user where id=:id{{end}}`), ModTime: time.Unix(1, 0)},
+	}
+	tpl := NewTemplate()
+	w := NewTemplateFSWatcher(tpl, fsys, "*.sql.tpl", time.Hour)
+	require.NoError(t, w.Start())
+	defer w.Stop()
+	reloaded, err := w.Check()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	fsys["user.sql.tpl"] = &fstest.MapFile{Data: []byte(`{{define "getUser"}}select name from user where id=:id{{end}}`), ModTime: time.Unix(2, 0)}
+	reloaded, err = w.Check()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	out, _, err := tpl.Exec("getUser", &VolumeMap{})
+	require.NoError(t, err)
+	require.Equal(t, "select name from user where id=:id", out)
+
+	fsys["user.sql.tpl"] = &fstest.MapFile{Data: []byte(`{{define "getUser"}}{{if}}{{end}}`), ModTime: time.Unix(3, 0)}
+	_, err = w.Check()
+	require.Error(t, err)
+	require.Error(t, w.LastError())
+	out, _, err = tpl.Exec("getUser", &VolumeMap{})
+	require.NoError(t, err)
+	require.Equal(t, "select name from user where id=:id", out)
+}
+
+func TestReloadKeepsAddTpl(t *testing.T) {
+	tpl := NewTemplate()
+	tpl.AddTpl("countUser", "select count(*) from user")
+	fsys := fstest.MapFS{"user.sql.tpl": {Data: []byte(`{{define "getUser"}}select * from user where id=:id{{end}}`)}}
+	_, err := tpl.LoadFS(fsys, "*.sql.tpl")
+	require.NoError(t, err)
+
+	fsys["user.sql.tpl"] = &fstest.MapFile{Data: []byte(`{{define "getUser"}}select name from user where id=:id{{end}}`)}
+	tplNames, err := tpl.Reload(fsys, "*.sql.tpl")
+	require.NoError(t, err)
+	require.Equal(t, []string{"getUser"}, tplNames)
+	out, _, err := tpl.Exec("countUser", &VolumeMap{})
+	require.NoError(t, err)
+	require.Equal(t, "select count(*) from user", out)
+	out, _, err = tpl.Exec("getUser", &VolumeMap{})
+	require.NoError(t, err)
+	require.Equal(t, "select name from user where id=:id", out)
+	require.NotNil(t, tpl.GetTemplate().Lookup("countUser"))
+	require.Contains(t, tpl.String(), "select count(*) from user")
+}
+
+func TestReloadKeepsOtherSources(t *testing.T) {
+	userFS := fstest.MapFS{"user.sql.tpl": {Data: []byte(`{{define "getUser"}}select * from user where id=:id{{end}}`)}}
+	orderFS := fstest.MapFS{"order.sql.tpl": {Data: []byte(`{{define "getOrder"}}select * from order where id=:id{{end}}`)}}
+	tpl := NewTemplate()
+	_, err := tpl.LoadFS(userFS, "*.sql.tpl")
+	require.NoError(t, err)
+	_, err = tpl.LoadFS(orderFS, "*.sql.tpl")
+	require.NoError(t, err)
+
+	userFS["user.sql.tpl"] = &fstest.MapFile{Data: []byte(`{{define "getUser"}}select name from user where id=:id{{end}}`)}
+	tplNames, err := tpl.Reload(userFS, "*.sql.tpl")
+	require.NoError(t, err)
+	require.Equal(t, []string{"getUser"}, tplNames)
+	out, _, err := tpl.Exec("getUser", &VolumeMap{})
+	require.NoError(t, err)
+	require.Equal(t, "select name from user where id=:id", out)
+	out, _, err = tpl.Exec("getOrder", &VolumeMap{})
+	require.NoError(t, err)
+	require.Equal(t, "select * from order where id=:id", out)
+	require.Same(t, tpl.GetTemplate(), tpl.Template)
+
+	// 其他来源中已存在的模板名称不能通过 Reload 覆盖
+	_, err = tpl.Reload(fstest.MapFS{"dup.sql.tpl": {Data: []byte(`{{define "getOrder"}}select 1{{end}}`)}}, "*.sql.tpl")
+	require.ErrorContains(t, err, "getOrder")
+	out, _, err = tpl.Exec("getOrder", &VolumeMap{})
+	require.NoError(t, err)
+	require.Equal(t, "select * from order where id=:id", out)
+}
+
+func TestAnalyze(t *testing.T) {
+	tpl := NewTemplate()
+	_, err := tpl.LoadFS(fstest.MapFS{
+		"user.sql.tpl": {Data: []byte(`
+{{define "where"}}where deleted_at={{zeroTime .}} and status=:status {{if .name}}and name=:name{{end}}{{end}}
+{{define "listUser"}}select * from user {{template "where" .}} and id in ({{in . .ids}}) and city=:city {{with .address}}and street={{.street}}{{end}} {{range .tags}}{{.label}}{{end}}{{end}}
+`)},
+	}, "*.sql.tpl")
+	require.NoError(t, err)
+	analysis, err := tpl.Analyze("listUser")
+	require.NoError(t, err)
+	require.Equal(t, []string{"address", "address.street", "city", "ids", "name", "status", "tags"}, analysis.Fields)
+	require.Equal(t, []string{"ZeroTime", IN_INDEX, "in_*"}, analysis.Writes)
+	require.Equal(t, []string{"in", "zeroTime"}, analysis.Funcs)
+	require.Equal(t, []string{"where"}, analysis.Templates)
+}
+
+func TestExplain(t *testing.T) {
+	tpl := NewTemplate()
+	tpl.AddTpl("listUser", "select * from user where id in ({{in . .ids}}) and name=:name and created_at>=:createdAt")
+	script := tengo.NewScript([]byte(`
+	explain:=tpl.explain("listUser",{ids:[1,2],name:"a"})
+	`))
+	require.NoError(t, script.Add("tpl", tpl))
+	c, err := script.Run()
+	require.NoError(t, err)
+	explain := tengo.ToInterface(c.Get("explain").Object()).(map[string]interface{})
+	require.Equal(t, "select * from user where id in (:in_1,:in_2) and name=:name and created_at>=:createdAt", explain["named"])
+	require.Equal(t, []interface{}{"createdAt"}, explain["missing"])
+	require.Equal(t, SQL_TYPE_SELECT, explain["sqlType"])
+
+	e, err := tpl.Explain("listUser", &VolumeMap{"ids": []int{1}, "name": "a", "createdAt": "2023-01-01"})
+	require.NoError(t, err)
+	require.Empty(t, e.Missing)
+	require.Equal(t, "select * from user where id in (?) and name=? and created_at>=?", e.Statement)
+	require.Equal(t, []interface{}{1, "a", "2023-01-01"}, e.Args)
+	require.Equal(t, "select * from user where id in (1) and name='a' and created_at>='2023-01-01'", e.SQL)
+}
+
+func TestTemplateDriverDialect(t *testing.T) {
+	tpl := NewTemplate()
+	tpl.SetDriverName(DRIVER_POSTGRES)
+	tpl.AddTpl("getUser", "select * from user where name=:name and id=:id")
+	e, err := tpl.Explain("getUser", &VolumeMap{"name": "O'Brien", "id": 1})
+	require.NoError(t, err)
+	require.Equal(t, "select * from user where name=$1 and id=$2", e.Statement)
+	require.Equal(t, "select * from user where name='O''Brien' and id=1", e.SQL)
+
+	script := tengo.NewScript([]byte(`
+	out:=tpl.exec("getUser",{name:"O'Brien",id:1})
+	pgSQL:=out.toSQL(ctx)
+	mysqlSQL:=out.toSQL(ctx,"mysql")
+	`))
+	require.NoError(t, script.Add("tpl", tpl))
+	require.NoError(t, script.Add("ctx", tengocontext.NewTengoContext(context.Background())))
+	c, err := script.Run()
+	require.NoError(t, err)
+	require.Equal(t, "select * from user where name='O''Brien' and id=1", c.Get("pgSQL").String())
+	require.Equal(t, `select * from user where name='O\'Brien' and id=1`, c.Get("mysqlSQL").String())
+}
+
+type pgDB struct {
+	tengo.ImmutableMap
+}
+
+func (db *pgDB) DriverName() string {
+	return DRIVER_POSTGRES
+}
+
+func TestTemplateOutExecDriverFromDB(t *testing.T) {
+	tpl := NewTemplate()
+	tpl.AddTpl("getUser", "select * from user where name=:name")
+	db := &pgDB{ImmutableMap: tengo.ImmutableMap{Value: map[string]tengo.Object{
+		"execOrQueryContext": &tengo.UserFunction{
+			Value: func(args ...tengo.Object) (tengo.Object, error) {
+				return args[1], nil
+			},
+		},
+	}}}
+	script := tengo.NewScript([]byte(`out:=tpl.exec("getUser",{name:"O'Brien"}).exec(ctx,db)`))
+	require.NoError(t, script.Add("tpl", tpl))
+	require.NoError(t, script.Add("db", db))
+	require.NoError(t, script.Add("ctx", tengocontext.NewTengoContext(context.Background())))
+	c, err := script.Run()
+	require.NoError(t, err)
+	require.Equal(t, "select * from user where name='O''Brien'", c.Get("out").String())
+}
@@ -0,0 +1,157 @@
+package tengotemplate
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/suifengpiao14/logchan/v2"
+)
+
+const (
+	LOG_INFO_TEMPLATE_RELOAD LogName = "LogInfoTemplateReload"
+)
+
+type LogInfoTemplateReload struct {
+	Context  context.Context
+	TplNames []string `json:"tplNames"`
+	Err      error    `json:"error"`
+	logchan.EmptyLogInfo
+}
+
+func (l *LogInfoTemplateReload) GetName() logchan.LogName {
+	return LOG_INFO_TEMPLATE_RELOAD
+}
+func (l *LogInfoTemplateReload) Error() error {
+	return l.Err
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// TemplateWatcher 轮询模板文件,有新增、删除、修改时调用 TengoTemplate.Reload 原子替换模板集合,新模板解析失败时保留原模板
+type TemplateWatcher struct {
+	tengoTemplate *TengoTemplate
+	fsys          fs.FS
+	pattern       string
+	interval      time.Duration
+	stamps        map[string]fileStamp
+	lastErr       error
+	lock          sync.Mutex
+	stop          chan struct{}
+	stopOnce      sync.Once
+}
+
+// NewTemplateWatcher 监听目录 dir 下匹配 pattern 的模板文件
+func NewTemplateWatcher(t *TengoTemplate, dir string, pattern string, interval time.Duration) (w *TemplateWatcher) {
+	return NewTemplateFSWatcher(t, os.DirFS(dir), pattern, interval)
+}
+
+// NewTemplateFSWatcher 同 NewTemplateWatcher,监听任意 fs.FS
+func NewTemplateFSWatcher(t *TengoTemplate, fsys fs.FS, pattern string, interval time.Duration) (w *TemplateWatcher) {
+	w = &TemplateWatcher{
+		tengoTemplate: t,
+		fsys:          fsys,
+		pattern:       pattern,
+		interval:      interval,
+		stop:          make(chan struct{}),
+	}
+	return w
+}
+
+// Start 同步加载一次模板(失败时返回错误,不启动轮询),之后在后台轮询
+func (w *TemplateWatcher) Start() (err error) {
+	_, err = w.Check()
+	if err != nil {
+		return err
+	}
+	go w.run()
+	return nil
+}
+
+// Stop 停止轮询
+func (w *TemplateWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
+
+// LastError 最近一次检查或加载的错误,成功加载后清空
+func (w *TemplateWatcher) LastError() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.lastErr
+}
+
+func (w *TemplateWatcher) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.Check()
+		}
+	}
+}
+
+// Check 文件有变化时重新加载,返回是否已重新加载
+func (w *TemplateWatcher) Check() (reloaded bool, err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	stamps, err := w.scan()
+	if err == nil && w.stamps != nil && equalStamps(stamps, w.stamps) {
+		return false, nil
+	}
+	logInfo := &LogInfoTemplateReload{}
+	defer func() {
+		w.lastErr = err
+		logInfo.Err = err
+		logchan.SendLogInfo(logInfo)
+	}()
+	if err != nil {
+		return false, err
+	}
+	w.stamps = stamps // 加载失败时同样记录,文件再次变化后才重试
+	logInfo.TplNames, err = w.tengoTemplate.Reload(w.fsys, w.pattern)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (w *TemplateWatcher) scan() (stamps map[string]fileStamp, err error) {
+	stamps = make(map[string]fileStamp)
+	err = fs.WalkDir(w.fsys, ".", func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !matchTemplateFile(w.pattern, filename) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stamps[filename] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	return stamps, err
+}
+
+func equalStamps(a map[string]fileStamp, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if other, ok := b[k]; !ok || !other.modTime.Equal(v.modTime) || other.size != v.size {
+			return false
+		}
+	}
+	return true
+}
@@ -1,8 +1,12 @@
 package tengotemplate
 
 import (
+	"encoding/json"
+	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/suifengpiao14/tengolib/util"
@@ -10,10 +14,15 @@ import (
 
 type VolumeInterface interface {
 	SetValue(key string, value interface{})
-	GetValue(key string, value interface{}) (ok bool)
+	GetValue(key string, value interface{}) (ok bool, err error)
 	ToMap() map[string]interface{}
 }
 
+// VolumeErrInterface SetValue 无法返回错误,写入失败的 volume 通过 Err 返回首次错误,TengoTemplate.Exec 执行后检查
+type VolumeErrInterface interface {
+	Err() error
+}
+
 // 私有定义，确保对VolumeMap 的操作全部通过 get/set 函数实现
 type VolumeMap map[string]interface{}
 
@@ -44,72 +53,261 @@ func (v *VolumeMap) SetValue(key string, value interface{}) {
 
 }
 
-func (v *VolumeMap) GetValue(key string, value interface{}) (ok bool) {
+// GetValue 获取值并转换为 value 指向的类型,键不存在或值为 nil 时 ok 为 false,转换失败时返回错误
+func (v *VolumeMap) GetValue(key string, value interface{}) (ok bool, err error) {
 	v.init()
 	tmp, ok := (*v)[key]
 	if !ok {
-		return ok
+		return ok, nil
+	}
+	ok, err = convertType(value, tmp)
+	if err != nil {
+		err = errors.WithMessagef(err, "volume key:%s", key)
+		return false, err
+	}
+	return ok, nil
+}
+
+// volumeViewKey 模板渲染视图中保存实际 volume 的键
+const volumeViewKey = "__volume"
+
+// volumeView 模板只能通过 map 读取 .a.b 形式的字段,非 map 类型的 volume 渲染时包装为 volumeView,读写转发至实际 volume
+type volumeView map[string]interface{}
+
+// templateData 返回模板渲染使用的数据,volume 本身为 map 时直接使用
+func templateData(volume VolumeInterface) interface{} {
+	if reflect.Indirect(reflect.ValueOf(volume)).Kind() == reflect.Map {
+		return volume
 	}
-	ok = convertType(value, tmp)
-	return ok
+	view := volumeView{}
+	for k, v := range volume.ToMap() {
+		view[k] = v
+	}
+	view[volumeViewKey] = volume
+	return view
+}
+
+func (v volumeView) volume() VolumeInterface {
+	return v[volumeViewKey].(VolumeInterface)
+}
+
+// SetValue 写入实际 volume 后刷新视图中对应的顶层键
+func (v volumeView) SetValue(key string, value interface{}) {
+	v.volume().SetValue(key, value)
+	top, _, nested := strings.Cut(key, ".")
+	if !nested {
+		v[top] = value
+		return
+	}
+	v[top] = v.volume().ToMap()[top]
+}
+
+func (v volumeView) GetValue(key string, value interface{}) (ok bool, err error) {
+	return v.volume().GetValue(key, value)
 }
 
-func convertType(dst interface{}, src interface{}) bool {
+func (v volumeView) ToMap() map[string]interface{} {
+	return v.volume().ToMap()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// 字符串转时间时依次尝试的格式
+var timeLayouts = []string{
+	"2006-01-02 15:04:05",
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+func convertType(dst interface{}, src interface{}) (ok bool, err error) {
 	if src == nil || dst == nil {
-		return false
+		return false, nil
 	}
-	rv := reflect.Indirect(reflect.ValueOf(dst))
-	if !rv.CanSet() {
-		err := errors.Errorf("dst :%#v reflect.CanSet() must return  true", dst)
-		panic(err)
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		err = errors.Errorf("dst :%#v must be a non-nil pointer", dst)
+		return false, err
 	}
-	rvT := rv.Type()
+	err = convertValue(rv.Elem(), src)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
+// convertValue 将 src 转换后写入 rv,支持数值、布尔、字符串、time.Time、json.Number、元素可转换的 slice、map 及经 json 转换的 struct
+func convertValue(rv reflect.Value, src interface{}) (err error) {
+	rvT := rv.Type()
+	if src == nil {
+		rv.Set(reflect.Zero(rvT))
+		return nil
+	}
 	rTmp := reflect.ValueOf(src)
-	if rTmp.CanConvert(rvT) {
-		realValue := rTmp.Convert(rvT)
-		rv.Set(realValue)
-		return true
+	if rTmp.Type().AssignableTo(rvT) {
+		rv.Set(rTmp)
+		return nil
+	}
+	if rvT == timeType {
+		t, err := toTime(src)
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(t))
+		return nil
 	}
 	srcStr := util.ToString(src)
 	switch rvT.Kind() {
-	case reflect.Int:
-		srcInt, err := strconv.Atoi(srcStr)
+	case reflect.String:
+		rv.SetString(srcStr)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if isNumberKind(rTmp.Kind()) && rTmp.Kind() != reflect.Float32 && rTmp.Kind() != reflect.Float64 {
+			srcStr = fmt.Sprintf("%d", src)
+		}
+		srcInt, err := strconv.ParseInt(srcStr, 10, rvT.Bits())
 		if err != nil {
-			err = errors.WithMessagef(err, "src:%s can`t convert to int", srcStr)
-			panic(err)
+			err = errors.WithMessagef(err, "src:%s can`t convert to %s", srcStr, rvT.String())
+			return err
 		}
-		rv.Set(reflect.ValueOf(srcInt))
-		return true
-	case reflect.Int64:
-		srcInt, err := strconv.ParseInt(srcStr, 10, 64)
+		rv.SetInt(srcInt)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if isNumberKind(rTmp.Kind()) && rTmp.Kind() != reflect.Float32 && rTmp.Kind() != reflect.Float64 {
+			srcStr = fmt.Sprintf("%d", src)
+		}
+		srcUint, err := strconv.ParseUint(srcStr, 10, rvT.Bits())
 		if err != nil {
-			err = errors.WithMessagef(err, "src:%s can`t convert to int64", srcStr)
-			panic(err)
+			err = errors.WithMessagef(err, "src:%s can`t convert to %s", srcStr, rvT.String())
+			return err
 		}
-		rv.SetInt(int64(srcInt))
-		return true
-	case reflect.Float64:
-		srcFloat, err := strconv.ParseFloat(srcStr, 64)
+		rv.SetUint(srcUint)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		srcFloat, err := strconv.ParseFloat(srcStr, rvT.Bits())
 		if err != nil {
-			err = errors.WithMessagef(err, "src:%s can`t convert to float64", srcStr)
-			panic(err)
+			err = errors.WithMessagef(err, "src:%s can`t convert to %s", srcStr, rvT.String())
+			return err
 		}
 		rv.SetFloat(srcFloat)
-		return true
+		return nil
 	case reflect.Bool:
 		srcBool, err := strconv.ParseBool(srcStr)
 		if err != nil {
 			err = errors.WithMessagef(err, "src:%s can`t convert to bool", srcStr)
-			panic(err)
+			return err
 		}
 		rv.SetBool(srcBool)
+		return nil
+	case reflect.Interface:
+		if rTmp.Type().Implements(rvT) {
+			rv.Set(rTmp)
+			return nil
+		}
+	case reflect.Slice:
+		if rTmp.Kind() == reflect.Slice || rTmp.Kind() == reflect.Array {
+			num := rTmp.Len()
+			slice := reflect.MakeSlice(rvT, num, num)
+			for i := 0; i < num; i++ {
+				err = convertValue(slice.Index(i), rTmp.Index(i).Interface())
+				if err != nil {
+					return errors.WithMessagef(err, "index:%d", i)
+				}
+			}
+			rv.Set(slice)
+			return nil
+		}
+		if rTmp.Kind() == reflect.String {
+			return unmarshalValue(rv, srcStr)
+		}
+	case reflect.Map:
+		if rTmp.Kind() == reflect.Map {
+			m := reflect.MakeMapWithSize(rvT, rTmp.Len())
+			iter := rTmp.MapRange()
+			for iter.Next() {
+				key := reflect.New(rvT.Key()).Elem()
+				err = convertValue(key, iter.Key().Interface())
+				if err != nil {
+					return err
+				}
+				val := reflect.New(rvT.Elem()).Elem()
+				err = convertValue(val, iter.Value().Interface())
+				if err != nil {
+					return errors.WithMessagef(err, "key:%v", iter.Key().Interface())
+				}
+				m.SetMapIndex(key, val)
+			}
+			rv.Set(m)
+			return nil
+		}
+		if rTmp.Kind() == reflect.String {
+			return unmarshalValue(rv, srcStr)
+		}
+	case reflect.Struct:
+		if rTmp.Kind() == reflect.String {
+			return unmarshalValue(rv, srcStr)
+		}
+		if rTmp.Kind() == reflect.Map || rTmp.Kind() == reflect.Struct {
+			b, err := json.Marshal(src)
+			if err != nil {
+				return errors.WithMessagef(err, "src:%v can`t convert to %s", src, rvT.String())
+			}
+			return unmarshalValue(rv, string(b))
+		}
+	}
+	if rTmp.CanConvert(rvT) {
+		rv.Set(rTmp.Convert(rvT))
+		return nil
+	}
+	err = errors.Errorf("can not convert %v(%s) to %s", src, rTmp.Type().String(), rvT.String())
+	return err
+}
+
+func isNumberKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
 		return true
-	case reflect.String:
-		rv.SetString(srcStr)
-		return true
+	}
+	return false
+}
 
+func unmarshalValue(rv reflect.Value, s string) (err error) {
+	ptr := reflect.New(rv.Type())
+	err = json.Unmarshal([]byte(s), ptr.Interface())
+	if err != nil {
+		err = errors.WithMessagef(err, "src:%s can`t convert to %s", s, rv.Type().String())
+		return err
+	}
+	rv.Set(ptr.Elem())
+	return nil
+}
+
+// toTime 支持 time.Time、unix 秒数及 timeLayouts 中格式的字符串(本地时区)
+func toTime(src interface{}) (t time.Time, err error) {
+	switch v := src.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		return *v, nil
+	case int:
+		return time.Unix(int64(v), 0), nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case json.Number:
+		sec, err := v.Int64()
+		if err != nil {
+			return t, errors.WithMessagef(err, "src:%s can`t convert to time.Time", v)
+		}
+		return time.Unix(sec, 0), nil
+	}
+	srcStr := util.ToString(src)
+	for _, layout := range timeLayouts {
+		t, err = time.ParseInLocation(layout, srcStr, time.Local)
+		if err == nil {
+			return t, nil
+		}
 	}
-	err := errors.Errorf("can not convert %v(%s) to %#v", src, rTmp.Type().String(), rvT.String())
-	panic(err)
+	err = errors.Errorf("src:%s can`t convert to time.Time", srcStr)
+	return t, err
 }
@@ -0,0 +1,114 @@
+package tengotemplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// VolumeJSON 以 json 文档为存储的 volume,键为 gjson/sjson 路径,如 user.address.city、ids.0
+type VolumeJSON struct {
+	lock sync.RWMutex
+	doc  string
+	err  error
+}
+
+// NewVolumeJSON doc 为空时使用 {}
+func NewVolumeJSON(doc string) (v *VolumeJSON, err error) {
+	if doc == "" {
+		doc = "{}"
+	}
+	if !gjson.Valid(doc) {
+		err = errors.Errorf("invalid json:%s", doc)
+		return nil, err
+	}
+	if !gjson.Parse(doc).IsObject() {
+		err = errors.Errorf("json must be object:%s", doc)
+		return nil, err
+	}
+	return &VolumeJSON{doc: doc}, nil
+}
+
+// SetValue 按 sjson 路径写入,中间节点不存在时自动创建;写入失败的错误通过 Err 获取
+func (v *VolumeJSON) SetValue(key string, value interface{}) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	doc, err := sjson.Set(v.doc, key, value)
+	if err != nil {
+		if v.err == nil {
+			v.err = errors.WithMessagef(err, "volume key:%s", key)
+		}
+		return
+	}
+	v.doc = doc
+}
+
+// GetValue 按 gjson 路径读取,路径不存在或值为 null 时 ok 为 false;数值以 json.Number 参与转换,避免大整数丢失精度
+func (v *VolumeJSON) GetValue(key string, value interface{}) (ok bool, err error) {
+	v.lock.RLock()
+	result := gjson.Get(v.doc, key)
+	v.lock.RUnlock()
+	if !result.Exists() || result.Type == gjson.Null {
+		return false, nil
+	}
+	var src interface{}
+	switch result.Type {
+	case gjson.Number:
+		src = json.Number(result.Raw)
+	case gjson.JSON:
+		src, err = decodeJSON(result.Raw)
+		if err != nil {
+			return false, err
+		}
+	default:
+		src = result.Value()
+	}
+	ok, err = convertType(value, src)
+	if err != nil {
+		err = errors.WithMessagef(err, "volume key:%s", key)
+		return false, err
+	}
+	return ok, nil
+}
+
+// ToMap 数值解析为 json.Number
+func (v *VolumeJSON) ToMap() (m map[string]interface{}) {
+	v.lock.RLock()
+	doc := v.doc
+	v.lock.RUnlock()
+	src, err := decodeJSON(doc)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	m, _ = src.(map[string]interface{})
+	return m
+}
+
+// String 返回当前 json 文档
+func (v *VolumeJSON) String() string {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.doc
+}
+
+// Err 返回首次 SetValue 失败的错误
+func (v *VolumeJSON) Err() error {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.err
+}
+
+func decodeJSON(s string) (out interface{}, err error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(s)))
+	decoder.UseNumber()
+	err = decoder.Decode(&out)
+	if err != nil {
+		err = errors.WithMessagef(err, "json:%s", s)
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,166 @@
+package tengotemplate
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/d5/tengo/v2"
+	"github.com/pkg/errors"
+)
+
+// VolumeTengoMap 直接读写 *tengo.Map 的 volume,键以 . 分隔访问嵌套 map,数组使用数字下标(只读),如 user.address.city、ids.0
+type VolumeTengoMap struct {
+	lock sync.RWMutex
+	Map  *tengo.Map
+	err  error
+}
+
+// NewVolumeTengoMap m 为 nil 时新建;写入会修改 m 本身
+func NewVolumeTengoMap(m *tengo.Map) *VolumeTengoMap {
+	if m == nil {
+		m = &tengo.Map{}
+	}
+	if m.Value == nil {
+		m.Value = make(map[string]tengo.Object)
+	}
+	return &VolumeTengoMap{Map: m}
+}
+
+// SetValue 中间节点不存在或不是 map 时创建 *tengo.Map(ImmutableMap 复制为 Map 后写入);转换失败的错误通过 Err 获取
+func (v *VolumeTengoMap) SetValue(key string, value interface{}) {
+	obj, err := toTengoObject(value)
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if err != nil {
+		if v.err == nil {
+			v.err = errors.WithMessagef(err, "volume key:%s", key)
+		}
+		return
+	}
+	segments := strings.Split(key, ".")
+	current := v.Map
+	for _, segment := range segments[:len(segments)-1] {
+		var next *tengo.Map
+		switch child := current.Value[segment].(type) {
+		case *tengo.Map:
+			next = child
+		case *tengo.ImmutableMap:
+			next = &tengo.Map{Value: make(map[string]tengo.Object, len(child.Value))}
+			for k, o := range child.Value {
+				next.Value[k] = o
+			}
+		default:
+			next = &tengo.Map{Value: make(map[string]tengo.Object)}
+		}
+		current.Value[segment] = next
+		current = next
+	}
+	current.Value[segments[len(segments)-1]] = obj
+}
+
+// GetValue 路径不存在或值为 undefined 时 ok 为 false
+func (v *VolumeTengoMap) GetValue(key string, value interface{}) (ok bool, err error) {
+	v.lock.RLock()
+	obj, ok := lookupTengoObject(v.Map, strings.Split(key, "."))
+	v.lock.RUnlock()
+	if !ok || obj == tengo.UndefinedValue {
+		return false, nil
+	}
+	ok, err = convertType(value, tengo.ToInterface(obj))
+	if err != nil {
+		err = errors.WithMessagef(err, "volume key:%s", key)
+		return false, err
+	}
+	return ok, nil
+}
+
+func (v *VolumeTengoMap) ToMap() (m map[string]interface{}) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	m, _ = tengo.ToInterface(v.Map).(map[string]interface{})
+	return m
+}
+
+// Err 返回首次 SetValue 失败的错误
+func (v *VolumeTengoMap) Err() error {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.err
+}
+
+func lookupTengoObject(obj tengo.Object, segments []string) (tengo.Object, bool) {
+	for _, segment := range segments {
+		var ok bool
+		switch o := obj.(type) {
+		case *tengo.Map:
+			obj, ok = o.Value[segment]
+		case *tengo.ImmutableMap:
+			obj, ok = o.Value[segment]
+		case *tengo.Array:
+			obj, ok = indexTengoArray(o.Value, segment)
+		case *tengo.ImmutableArray:
+			obj, ok = indexTengoArray(o.Value, segment)
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return obj, true
+}
+
+func indexTengoArray(arr []tengo.Object, segment string) (tengo.Object, bool) {
+	index, err := strconv.Atoi(segment)
+	if err != nil || index < 0 || index >= len(arr) {
+		return nil, false
+	}
+	return arr[index], true
+}
+
+// toTengoObject tengo.FromInterface 不支持的类型(如 []int、结构体)经 json 转换,整数还原为 int64,避免超过 2^53 的值丢失精度
+func toTengoObject(value interface{}) (obj tengo.Object, err error) {
+	obj, err = tengo.FromInterface(value)
+	if err == nil {
+		return obj, nil
+	}
+	b, jsonErr := json.Marshal(value)
+	if jsonErr != nil {
+		return nil, err
+	}
+	data, jsonErr := decodeJSON(string(b))
+	if jsonErr != nil {
+		return nil, err
+	}
+	data, err = fromJSONNumber(data)
+	if err != nil {
+		return nil, err
+	}
+	return tengo.FromInterface(data)
+}
+
+// fromJSONNumber 将 json.Number 转换为 int64,非整数转换为 float64
+func fromJSONNumber(data interface{}) (out interface{}, err error) {
+	switch d := data.(type) {
+	case json.Number:
+		if i, err := d.Int64(); err == nil {
+			return i, nil
+		}
+		return d.Float64()
+	case map[string]interface{}:
+		for k, v := range d {
+			d[k], err = fromJSONNumber(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, v := range d {
+			d[i], err = fromJSONNumber(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return data, nil
+}
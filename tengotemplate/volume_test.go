@@ -0,0 +1,184 @@
+package tengotemplate
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/d5/tengo/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVolumeMapGetValue(t *testing.T) {
+	volume := VolumeMap{
+		"int":    "12",
+		"int8":   int64(300),
+		"uint":   json.Number("7"),
+		"float":  "1.5",
+		"bool":   "true",
+		"number": 65,
+		"time":   "2023-01-02 03:04:05",
+		"ids":    []interface{}{"1", 2, json.Number("3")},
+		"names":  `["a","b"]`,
+		"attrs":  map[string]interface{}{"age": "18"},
+		"nil":    nil,
+	}
+	t.Run("numbers", func(t *testing.T) {
+		var i int
+		ok, err := volume.GetValue("int", &i)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, 12, i)
+
+		var u uint64
+		ok, err = volume.GetValue("uint", &u)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, uint64(7), u)
+
+		var f float32
+		_, err = volume.GetValue("float", &f)
+		require.NoError(t, err)
+		require.Equal(t, float32(1.5), f)
+
+		var b bool
+		_, err = volume.GetValue("bool", &b)
+		require.NoError(t, err)
+		require.True(t, b)
+	})
+	t.Run("overflow", func(t *testing.T) {
+		var i8 int8
+		ok, err := volume.GetValue("int8", &i8)
+		require.Error(t, err)
+		require.False(t, ok)
+	})
+	t.Run("intToString", func(t *testing.T) {
+		var s string
+		_, err := volume.GetValue("number", &s)
+		require.NoError(t, err)
+		require.Equal(t, "65", s)
+	})
+	t.Run("time", func(t *testing.T) {
+		var tm time.Time
+		_, err := volume.GetValue("time", &tm)
+		require.NoError(t, err)
+		require.Equal(t, "2023-01-02 03:04:05", tm.Format("2006-01-02 15:04:05"))
+	})
+	t.Run("sliceAndMap", func(t *testing.T) {
+		var ids []int
+		_, err := volume.GetValue("ids", &ids)
+		require.NoError(t, err)
+		require.Equal(t, []int{1, 2, 3}, ids)
+
+		var names []string
+		_, err = volume.GetValue("names", &names)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, names)
+
+		var attrs map[string]int
+		_, err = volume.GetValue("attrs", &attrs)
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{"age": 18}, attrs)
+	})
+	t.Run("missing", func(t *testing.T) {
+		var i int
+		ok, err := volume.GetValue("notExists", &i)
+		require.NoError(t, err)
+		require.False(t, ok)
+		ok, err = volume.GetValue("nil", &i)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		var i int
+		_, err := volume.GetValue("bool", &i)
+		require.Error(t, err)
+		_, err = volume.GetValue("int", i)
+		require.Error(t, err)
+	})
+}
+
+func TestVolumeJSON(t *testing.T) {
+	volume, err := NewVolumeJSON(`{"id":9007199254740993,"user":{"address":{"city":"shenzhen"}},"ids":[1,2]}`)
+	require.NoError(t, err)
+	var id int64
+	ok, err := volume.GetValue("id", &id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(9007199254740993), id)
+
+	var ids []int
+	_, err = volume.GetValue("ids", &ids)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2}, ids)
+
+	volume.SetValue("user.address.street", "nanshan")
+	require.NoError(t, volume.Err())
+	var street string
+	ok, err = volume.GetValue("user.address.street", &street)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "nanshan", street)
+
+	tpl := NewTemplate()
+	tpl.AddTpl("getUser", `select * from user where city='{{.user.address.city}}' and id in ({{in . .ids}})`)
+	out, changedVolume, err := tpl.Exec("getUser", volume)
+	require.NoError(t, err)
+	require.Equal(t, "select * from user where city='shenzhen' and id in (:in_1,:in_2)", out)
+	_, err = changedVolume.GetValue("in_2", &id)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), id)
+
+	_, err = NewVolumeJSON(`[1]`)
+	require.Error(t, err)
+}
+
+func TestVolumeTengoMap(t *testing.T) {
+	m := &tengo.Map{Value: map[string]tengo.Object{
+		"user": &tengo.ImmutableMap{Value: map[string]tengo.Object{
+			"name": &tengo.String{Value: "tom"},
+		}},
+		"ids": &tengo.Array{Value: []tengo.Object{&tengo.Int{Value: 3}}},
+	}}
+	volume := NewVolumeTengoMap(m)
+	var name string
+	ok, err := volume.GetValue("user.name", &name)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "tom", name)
+
+	var id int
+	_, err = volume.GetValue("ids.0", &id)
+	require.NoError(t, err)
+	require.Equal(t, 3, id)
+
+	volume.SetValue("user.address.city", "shenzhen")
+	volume.SetValue("tags", []int{1, 2})
+	volume.SetValue("big", []int64{9007199254740993})
+	volume.SetValue("order", struct {
+		ID int64 `json:"id"`
+	}{ID: 9007199254740995})
+	require.NoError(t, volume.Err())
+	var big []int64
+	_, err = volume.GetValue("big", &big)
+	require.NoError(t, err)
+	require.Equal(t, []int64{9007199254740993}, big)
+	var orderID int64
+	_, err = volume.GetValue("order.id", &orderID)
+	require.NoError(t, err)
+	require.Equal(t, int64(9007199254740995), orderID)
+	city, ok := lookupTengoObject(m, []string{"user", "address", "city"})
+	require.True(t, ok)
+	require.Equal(t, "shenzhen", city.(*tengo.String).Value)
+
+	tpl := NewTemplate()
+	tpl.AddTpl("getUser", `{{.user.name}} {{.user.address.city}} {{len .tags}}`)
+	out, _, err := tpl.Exec("getUser", volume)
+	require.NoError(t, err)
+	require.Equal(t, "tom shenzhen 2", out)
+
+	volume.SetValue("ch", make(chan int))
+	require.Error(t, volume.Err())
+	_, _, err = tpl.Exec("getUser", volume)
+	require.Error(t, err)
+}